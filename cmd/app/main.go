@@ -2,39 +2,114 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
-
 	intl "order-service/internal"
 )
 
 func main() {
-	cfg := intl.Env()
+	configPath := flag.String("config", "", "YAML config file (default $CONFIG_FILE); environment variables override it")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
+	cfg, err := intl.Env(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	intl.SetLogLevel(cfg.LogLevel)
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	pool, err := pgxpool.New(ctx, cfg.PGURL)
+	pool, err := intl.OpenPool(ctx, cfg.PGURL, cfg.PGPool)
 	if err != nil {
-		panic(err)
+		log.Fatalf("postgres: %v", err)
 	}
 	defer pool.Close()
+	// под docker-compose PostgreSQL может ещё запускаться
+	if err := intl.WaitForDB(ctx, pool, cfg.PGStartup); err != nil {
+		log.Fatalf("postgres: %v", err)
+	}
 
 	repo := intl.NewRepo(pool)
-	cache := intl.NewCache()
+	cache, err := intl.NewOrderCache(cfg)
+	if err != nil {
+		log.Fatalf("cache: %v", err)
+	}
+	mem, _ := cache.(*intl.Cache)
+	if t, ok := cache.(*intl.TieredCache); ok {
+		mem = t.L1
+	}
+	if mem != nil {
+		go mem.RunJanitor(ctx, cfg.CacheJanitor)
+	}
+
+	// прогрев: снимок + изменения после него, иначе последние N из БД
+	warmed := false
+	if mem != nil && cfg.SnapshotPath != "" {
+		err := intl.RestoreSnapshot(ctx, cfg.SnapshotPath, cfg.SnapshotMaxAge, mem, repo, cfg.WarmN)
+		if err != nil {
+			log.Printf("Snapshot restore skipped: %v", err)
+			mem.DeleteAllItems() // только кэш процесса: общий Redis мог заполнить кто-то другой
+		}
+		warmed = err == nil
+	}
+	warmer := intl.NewWarmer(cache, repo, cfg.WarmOptions())
+	if !warmed {
+		// в фоне: HTTP начинает отвечать сразу, промахи идут в БД
+		go func() {
+			if _, err := warmer.RunWhenReady(ctx); err != nil {
+				log.Printf("Warm-up error: %v", err)
+			}
+		}()
+	}
 
-	// прогрев
-	if list, err := repo.LoadRecent(ctx, cfg.WarmN); err == nil {
-		cache.Warm(list)
+	// учёт чтений для стратегии прогрева popular
+	var access *intl.AccessCounter
+	if cfg.AccessTracking {
+		access = intl.NewAccessCounter(repo)
+		go access.Run(ctx, cfg.AccessFlush)
+	}
+
+	// инвалидация локального кэша по изменениям с других реплик; общему Redis она не нужна
+	if cfg.CacheInvalidation && mem != nil {
+		local := intl.NewWarmer(mem, repo, cfg.WarmOptions())
+		go intl.NewInvalidator(cfg.PGURL, mem, repo, local).Run(ctx)
+	}
+
+	// сверка кэша с БД
+	var verifier *intl.Verifier
+	if cfg.VerifyInterval > 0 {
+		verifier = intl.NewVerifier(cache, repo, cfg.VerifySample, cfg.VerifyMode)
+		if verifier != nil {
+			go verifier.Run(ctx, cfg.VerifyInterval)
+		}
 	}
 
 	// kafka consumer
-	consumer := intl.NewConsumer(cfg.Brokers, cfg.Topic, cfg.Group, cache, repo)
+	var dlq *intl.DeadLetters
+	if cfg.DLQTopic != "" {
+		if dlq, err = intl.NewDeadLetters(cfg.Brokers, cfg.DLQTopic, cfg.Kafka); err != nil {
+			log.Fatal(err)
+		}
+		defer dlq.Close()
+	}
+	consumer, err := intl.NewConsumer(cfg.ConsumerOptions(), cache, repo, dlq)
+	if err != nil {
+		log.Fatal(err)
+	}
 	go func() {
 		err := consumer.Start(ctx)
 		if err != nil {
@@ -44,9 +119,10 @@ func main() {
 	defer consumer.Close()
 
 	// http
+	api := intl.NewHTTP(cache, repo, access, &cfg)
 	srv := &http.Server{
 		Addr:         cfg.Addr,
-		Handler:      intl.NewHTTP(cache, repo, &cfg),
+		Handler:      api,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
@@ -57,6 +133,27 @@ func main() {
 		}
 	}()
 
+	// SIGHUP: перечитать конфигурацию и применить безопасные настройки без перезапуска
+	reloader := intl.NewReloader(cfg, func() (intl.Config, error) { return intl.Env(*configPath) }, cache, api)
+	go reloader.Run(ctx)
+
+	// admin
+	var adminSrv *http.Server
+	if cfg.AdminAddr != "" {
+		adminSrv = &http.Server{
+			Addr:         cfg.AdminAddr,
+			Handler:      intl.NewAdminHTTP(cache, warmer, verifier, consumer, &cfg),
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: time.Minute, // прогрев может быть долгим
+		}
+		go func() {
+			err := adminSrv.ListenAndServe()
+			if err != nil {
+				log.Printf("Admin server start error: %v", err)
+			}
+		}()
+	}
+
 	<-ctx.Done()
 	shCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -64,4 +161,14 @@ func main() {
 	if err != nil {
 		log.Printf("shutdown error: %v", err)
 	}
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(shCtx); err != nil {
+			log.Printf("admin shutdown error: %v", err)
+		}
+	}
+	if mem != nil && cfg.SnapshotPath != "" {
+		if err := intl.SaveSnapshot(cfg.SnapshotPath, mem); err != nil {
+			log.Printf("Snapshot save error: %v", err)
+		}
+	}
 }
@@ -23,14 +23,23 @@ func main() {
 	interval := flag.Duration("interval", time.Second, "interval between orders, e.g. 500ms, 1s, 2s")
 	brokersFlag := flag.String("brokers", getenv("KAFKA_BROKERS", "localhost:29092"), "comma-separated kafka brokers")
 	topic := flag.String("topic", getenv("KAFKA_TOPIC", "orders"), "kafka topic")
+	configPath := flag.String("config", getenv("CONFIG_FILE", ""), "YAML config file with KAFKA_TLS_*/KAFKA_SASL_* settings; environment overrides it")
 	flag.Parse()
 
+	sec, err := intl.LoadKafkaSecurity(*configPath)
+	if err != nil {
+		log.Fatalf("kafka security config: %v", err)
+	}
+
 	brokers := strings.Split(*brokersFlag, ",")
 	cfg := sarama.NewConfig()
 	cfg.Version = sarama.V2_1_0_0
 	cfg.Producer.RequiredAcks = sarama.WaitForAll
 	cfg.Producer.Return.Successes = true
 	cfg.Producer.Retry.Max = 3
+	if err := sec.Apply(cfg); err != nil {
+		log.Fatalf("kafka security: %v", err)
+	}
 
 	prod, err := sarama.NewSyncProducer(brokers, cfg)
 	if err != nil {
@@ -126,7 +135,7 @@ func genItems(track string) []intl.Item {
 	for i := 0; i < n; i++ {
 		price := 100 + rng.Intn(900)
 		sale := []int{0, 10, 20, 30}[rng.Intn(4)]
-		total := int(float64(price) * (1 - float64(sale)/100))
+		total := price * (100 - sale) / 100 // целочисленно: float64 даёт 170*0.7 = 118.99… -> 118
 		items = append(items, intl.Item{
 			ChrtID:      900000 + rng.Intn(999999),
 			TrackNumber: track,
@@ -1,3 +1,4 @@
+DROP TABLE if EXISTS order_access;
 DROP TABLE if EXISTS items;
 DROP TABLE if EXISTS payments;
 DROP TABLE if EXISTS deliveries;
@@ -0,0 +1,3 @@
+-- Индексы для поиска заказов по вторичным ключам (payments.transaction уже UNIQUE)
+CREATE INDEX IF NOT EXISTS idx_orders_track_number ON orders(track_number);
+CREATE INDEX IF NOT EXISTS idx_orders_customer_id ON orders(customer_id);
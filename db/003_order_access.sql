@@ -0,0 +1,9 @@
+-- Счётчики чтений заказов для прогрева кэша по популярности (WARM_STRATEGY=popular)
+CREATE TABLE IF NOT EXISTS order_access (
+  order_uid   TEXT PRIMARY KEY REFERENCES orders(order_uid) ON DELETE CASCADE,
+  hits        BIGINT      NOT NULL DEFAULT 0,
+  last_access TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_access_hits ON order_access(hits DESC);
+CREATE INDEX IF NOT EXISTS idx_orders_date_created ON orders(date_created DESC);
@@ -0,0 +1,5 @@
+-- Версия заказа для защиты от устаревших и переупорядоченных сообщений:
+-- Repo.Upsert применяет заказ, только если пара (version, version_seq) больше сохранённой.
+-- version_seq — offset сообщения Kafka для версий по времени сообщения, 0 — для явных.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS version_seq BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// AccessCounter копит чтения заказов в памяти и периодически сбрасывает их в order_access.
+// Данные нужны стратегии прогрева popular.
+type AccessCounter struct {
+	repo *Repo
+
+	mu   sync.Mutex
+	hits map[string]int64
+}
+
+func NewAccessCounter(repo *Repo) *AccessCounter {
+	return &AccessCounter{repo: repo, hits: make(map[string]int64)}
+}
+
+func (a *AccessCounter) Record(id string) {
+	a.mu.Lock()
+	a.hits[id]++
+	a.mu.Unlock()
+}
+
+// Run сбрасывает счётчики раз в every; при отмене ctx делает последний сброс.
+func (a *AccessCounter) Run(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			a.flush(fctx)
+			cancel()
+			return
+		case <-t.C:
+			a.flush(ctx)
+		}
+	}
+}
+
+func (a *AccessCounter) flush(ctx context.Context) {
+	a.mu.Lock()
+	hits := a.hits
+	a.hits = make(map[string]int64, len(hits))
+	a.mu.Unlock()
+	if len(hits) == 0 {
+		return
+	}
+	if err := a.repo.AddAccess(ctx, hits); err != nil {
+		log.Printf("[ACCESS] flush %d counters: %v", len(hits), err)
+	}
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Admin — служебные ручки управления кэшем. Поднимаются на отдельном адресе (ADMIN_ADDR)
+// и требуют заголовок Authorization: Bearer <ADMIN_TOKEN>.
+type Admin struct {
+	cache    OrderCache
+	warmer   *Warmer
+	verifier *Verifier // nil — сверка выключена
+	consumer *Consumer
+	cfg      *Config
+}
+
+func NewAdminHTTP(cache OrderCache, warmer *Warmer, verifier *Verifier, consumer *Consumer, cfg *Config) http.Handler {
+	a := &Admin{cache: cache, warmer: warmer, verifier: verifier, consumer: consumer, cfg: cfg}
+	a.publishMetrics()
+	r := httprouter.New()
+	r.Handler(http.MethodGet, "/debug/vars", a.authHandler(expvar.Handler()))
+	r.GET("/admin/verify", a.auth(a.verifyStats))
+	r.POST("/admin/verify", a.auth(a.verifyNow))
+	r.GET("/admin/cache/stats", a.auth(a.stats))
+	r.POST("/admin/cache/warm", a.auth(a.warm))
+	r.DELETE("/admin/cache", a.auth(a.flush))
+	r.DELETE("/admin/cache/:id", a.auth(a.evict))
+	return r
+}
+
+// publishMetrics регистрирует счётчики кэша, сверки и consumer'а в expvar (/debug/vars).
+func (a *Admin) publishMetrics() {
+	if sc, ok := a.cache.(StatsCache); ok && expvar.Get("cache") == nil {
+		expvar.Publish("cache", expvar.Func(func() any { return sc.Stats() }))
+	}
+	if a.verifier != nil && expvar.Get("cache_verify") == nil {
+		expvar.Publish("cache_verify", expvar.Func(func() any { return a.verifier.Stats() }))
+	}
+	if a.consumer != nil && expvar.Get("consumer") == nil {
+		expvar.Publish("consumer", expvar.Func(func() any { return a.consumer.Stats() }))
+	}
+}
+
+func (a *Admin) authHandler(next http.Handler) http.Handler {
+	h := a.auth(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) { next.ServeHTTP(w, r) })
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { h(w, r, nil) })
+}
+
+func (a *Admin) auth(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || a.cfg.AdminToken == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(a.cfg.AdminToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r, ps)
+	}
+}
+
+func (a *Admin) stats(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	resp := map[string]any{"backend": a.cfg.CacheBackend}
+	if sc, ok := a.cache.(StatsCache); ok {
+		resp["stats"] = sc.Stats()
+	}
+	writeJSON(w, resp)
+}
+
+func (a *Admin) warm(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	start := time.Now()
+	n, err := a.warmer.Run(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		log.Printf("[ADMIN] warm error: %v", err)
+		return
+	}
+	writeJSON(w, map[string]any{"loaded": n, "duration_ms": time.Since(start).Milliseconds()})
+}
+
+func (a *Admin) verifyStats(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	if a.verifier == nil {
+		http.Error(w, "verifier is disabled", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, a.verifier.Stats())
+}
+
+func (a *Admin) verifyNow(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if a.verifier == nil {
+		http.Error(w, "verifier is disabled", http.StatusNotFound)
+		return
+	}
+	n := a.verifier.Verify(r.Context())
+	writeJSON(w, map[string]any{"diverged": n, "stats": a.verifier.Stats()})
+}
+
+func (a *Admin) flush(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	a.cache.DeleteAllItems()
+	log.Printf("[ADMIN] cache flushed")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) evict(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	a.cache.Delete(id)
+	log.Printf("[ADMIN] evicted id=%s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Encoding error: %v", err)
+	}
+}
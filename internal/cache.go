@@ -1,43 +1,561 @@
 package internal
 
-import "sync"
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
+// OrderCache — хранилище заказов перед БД. Реализации: Cache (в памяти процесса), RedisCache (общий для реплик)
+// и TieredCache (Cache перед RedisCache).
+// Ошибки бэкенда не пробрасываются: недоступный кэш ведёт себя как пустой.
+type OrderCache interface {
+	Get(id string) (*Order, bool)
+	Set(o *Order)
+	Warm(list []*Order)
+	Delete(orderUID string)
+	DeleteAllItems()
+}
+
+// NewOrderCache создаёт кэш, выбранный в конфиге (CACHE_BACKEND).
+func NewOrderCache(cfg Config) (OrderCache, error) {
+	switch cfg.CacheBackend {
+	case "", "memory":
+		return NewCache(cfg.CacheOptions()), nil
+	case "redis":
+		return NewRedisCache(cfg.RedisOptions())
+	case "tiered":
+		l2, err := NewRedisCache(cfg.RedisOptions())
+		if err != nil {
+			return nil, err
+		}
+		return NewTieredCache(NewCache(cfg.L1Options()), l2, cfg.TieredOptions()), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.CacheBackend)
+	}
+}
+
+// ReconfigureCache применяет к работающему кэшу новые лимиты, TTL и политику уровней из конфига.
+// Бэкенд и число сегментов меняются только перезапуском.
+func ReconfigureCache(cache OrderCache, cfg Config) {
+	switch c := cache.(type) {
+	case *Cache:
+		c.SetOptions(cfg.CacheOptions())
+	case *RedisCache:
+		c.SetTTL(cfg.CacheTTL)
+	case *TieredCache:
+		c.L1.SetOptions(cfg.L1Options())
+		if r, ok := c.L2.(*RedisCache); ok {
+			r.SetTTL(cfg.CacheTTL)
+		}
+		c.SetOptions(cfg.TieredOptions())
+	}
+}
+
+// CacheOptions — ограничения кэша. Нулевое значение поля означает «без ограничения».
+// Лимиты делятся поровну между сегментами (Shards, по умолчанию 1).
+type CacheOptions struct {
+	MaxEntries int
+	MaxBytes   int64
+	TTL        time.Duration
+	Shards     int
+
+	// NegativeTTL — сколько помнить, что заказа нет в БД; 0 — не помнить.
+	// NegativeMax ограничивает число таких записей (защита от перебора случайных id).
+	NegativeTTL time.Duration
+	NegativeMax int
+
+	// Gzip — хранить рядом с JSON сжатый вариант для клиентов с Accept-Encoding: gzip.
+	Gzip bool
+}
+
+// CacheStats — снимок счётчиков кэша.
+type CacheStats struct {
+	Entries      int       `json:"entries"`
+	Bytes        int64     `json:"bytes"`
+	Hits         uint64    `json:"hits"`
+	Misses       uint64    `json:"misses"`
+	Evictions    uint64    `json:"evictions"`
+	EvictedBytes uint64    `json:"evicted_bytes"`
+	Expired      uint64    `json:"expired"`
+	Negative     int       `json:"negative"`
+	NegativeHits uint64    `json:"negative_hits"`
+	LastWarm     time.Time `json:"last_warm"`
+}
+
+// SamplingCache — кэш, умеющий отдать случайную выборку ключей (для сверки с БД).
+type SamplingCache interface {
+	SampleIDs(n int) []string
+}
+
+// PeekingCache — чтение для внутренних проверок (сверка, инвалидация): не считается попаданием
+// или промахом и не меняет порядок LRU.
+type PeekingCache interface {
+	Peek(id string) (*Order, bool)
+}
+
+// StatsCache — кэш, отдающий счётчики (для админки).
+type StatsCache interface {
+	Stats() CacheStats
+}
+
+// NegativeCache — запоминание отсутствующих заказов. Set и Delete по id сбрасывают отметку.
+type NegativeCache interface {
+	SetMissing(id string)
+	IsMissing(id string) bool
+}
+
+type cacheEntry struct {
+	o       *Order
+	enc     *EncodedOrder // nil, если закодировать не удалось
+	stored  time.Time
+	size    int64
+	expires time.Time // нулевое — без срока жизни
+}
+
+func (e *cacheEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// Cache — LRU-кэш заказов с ограничением по числу записей и по оценочному объёму.
+// Хранимые заказы неизменяемы: Set/Warm сохраняют копию, а чтения отдают копии,
+// поэтому изменения у вызывающего не видны другим читателям.
+// order_uid хешируется в один из независимо блокируемых сегментов, поэтому Warm и запись
+// блокируют только свои сегменты, а DeleteAllItems атомарно подменяет весь набор.
 type Cache struct {
-	mu sync.RWMutex
-	m  map[string]*Order
+	shards atomic.Pointer[[]*cacheShard]
+	opts   atomic.Pointer[CacheOptions] // подменяется целиком в SetOptions
+
+	hits         atomic.Uint64
+	misses       atomic.Uint64
+	evictions    atomic.Uint64
+	evictedBytes atomic.Uint64
+	expired      atomic.Uint64
+	negativeHits atomic.Uint64
+	lastWarm     atomic.Int64 // unix nano
 }
 
-func NewCache() *Cache { return &Cache{m: make(map[string]*Order)} }
+// cacheShard — сегмент кэша со своим LRU-списком и вторичными индексами.
+// Get считается использованием, поэтому берёт эксклюзивную блокировку.
+type cacheShard struct {
+	c *Cache // счётчики общие на весь кэш
+
+	mu         sync.Mutex
+	ll         *list.List // front — самые свежие по использованию
+	m          map[string]*list.Element
+	idx        orderIndexes
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
+
+	missing    map[string]time.Time // order_uid -> до какого момента считаем, что заказа нет
+	maxMissing int
+}
+
+func NewCache(opts CacheOptions) *Cache {
+	if opts.Shards <= 0 {
+		opts.Shards = 1
+	}
+	c := &Cache{}
+	c.opts.Store(&opts)
+	shards := c.newShards()
+	c.shards.Store(&shards)
+	return c
+}
+
+func (c *Cache) options() CacheOptions { return *c.opts.Load() }
+
+// SetOptions меняет лимиты, TTL и настройки отрицательного кэша на ходу; лишние записи сразу вытесняются.
+// Новый TTL действует для записей, сохранённых после вызова. Число сегментов не меняется.
+func (c *Cache) SetOptions(opts CacheOptions) {
+	opts.Shards = c.options().Shards
+	c.opts.Store(&opts)
+	for _, s := range c.shardList() {
+		s.mu.Lock()
+		s.setLimits(opts)
+		s.evict()
+		s.mu.Unlock()
+	}
+}
+
+func (c *Cache) newShards() []*cacheShard {
+	opts := c.options()
+	shards := make([]*cacheShard, opts.Shards)
+	for i := range shards {
+		shards[i] = &cacheShard{
+			c:       c,
+			ll:      list.New(),
+			m:       make(map[string]*list.Element),
+			idx:     newOrderIndexes(),
+			missing: make(map[string]time.Time),
+		}
+		shards[i].setLimits(opts)
+	}
+	return shards
+}
+
+// setLimits делит лимиты кэша на сегмент. Вызывается под s.mu или до публикации сегмента.
+func (s *cacheShard) setLimits(opts CacheOptions) {
+	n := opts.Shards
+	s.maxEntries = ceilDiv(opts.MaxEntries, n)
+	s.maxBytes = int64(ceilDiv(int(opts.MaxBytes), n))
+	s.maxMissing = ceilDiv(opts.NegativeMax, n)
+}
+
+func (c *Cache) shardList() []*cacheShard { return *c.shards.Load() }
+
+func (c *Cache) shard(id string) *cacheShard {
+	shards := c.shardList()
+	if len(shards) == 1 {
+		return shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return shards[h.Sum32()%uint32(len(shards))]
+}
 
 func (c *Cache) Get(id string) (*Order, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	o, ok := c.m[id]
-	return o, ok
+	e, ok := c.get(id)
+	if !ok {
+		return nil, false
+	}
+	return e.o.Clone(), true
+}
+
+// Peek — как Get, но без счётчиков и без переноса записи в начало LRU; просроченная запись — промах.
+func (c *Cache) Peek(id string) (*Order, bool) {
+	s := c.shard(id)
+	s.mu.Lock()
+	el, ok := s.m[id]
+	var e *cacheEntry
+	if ok {
+		e = el.Value.(*cacheEntry)
+	}
+	s.mu.Unlock()
+	if !ok || e.expired(time.Now()) {
+		return nil, false
+	}
+	return e.o.Clone(), true
+}
+
+// GetEncoded — как Get, но вместе с готовыми байтами ответа и возрастом записи.
+func (c *Cache) GetEncoded(id string) (CachedOrder, bool) {
+	e, ok := c.get(id)
+	if !ok {
+		return CachedOrder{}, false
+	}
+	return CachedOrder{Order: e.o.Clone(), Encoded: e.enc, StoredAt: e.stored}, true
+}
+
+func (c *Cache) get(id string) (*cacheEntry, bool) {
+	s := c.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.m[id]
+	if ok && el.Value.(*cacheEntry).expired(time.Now()) {
+		// ленивое удаление: просроченная запись — обычный промах
+		s.remove(el)
+		c.expired.Add(1)
+		ok = false
+	}
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	s.ll.MoveToFront(el)
+	c.hits.Add(1)
+	return el.Value.(*cacheEntry), true
 }
 
 func (c *Cache) Set(o *Order) {
-	c.mu.Lock()
-	c.m[o.OrderUID] = o
-	c.mu.Unlock()
+	e := c.newEntry(o) // кодирование — до блокировки
+	s := c.shard(o.OrderUID)
+	s.mu.Lock()
+	s.set(e, false)
+	s.evict()
+	s.mu.Unlock()
 }
 
+// Warm ожидает список, отсортированный по убыванию важности (как его читает Warmer): новые записи
+// встают в хвост LRU по порядку, поэтому при переполнении вытесняются наименее важные, а каждый
+// следующий вызов (следующая порция прогрева) оказывается позади предыдущих и позади прочитанных
+// записей. Уже закэшированные записи обновляются на месте.
+// Сегменты загружаются по одному, читатели остальных сегментов не ждут.
 func (c *Cache) Warm(list []*Order) {
-	c.mu.Lock()
+	shards := c.shardList()
+	parts := make(map[*cacheShard][]*cacheEntry, len(shards))
 	for _, o := range list {
-		c.m[o.OrderUID] = o
+		s := c.shard(o.OrderUID)
+		parts[s] = append(parts[s], c.newEntry(o))
+	}
+	for s, part := range parts {
+		s.mu.Lock()
+		for _, e := range part {
+			s.set(e, true)
+		}
+		s.evict()
+		s.mu.Unlock()
 	}
-	c.mu.Unlock()
+	c.lastWarm.Store(time.Now().UnixNano())
 }
 
 func (c *Cache) Delete(orderUID string) {
-	c.mu.Lock()
-	delete(c.m, orderUID)
-	c.mu.Unlock()
+	s := c.shard(orderUID)
+	s.mu.Lock()
+	if el, ok := s.m[orderUID]; ok {
+		s.remove(el)
+	}
+	delete(s.missing, orderUID)
+	s.mu.Unlock()
+}
+
+// SetMissing запоминает, что заказа нет в БД, на NegativeTTL.
+func (c *Cache) SetMissing(id string) {
+	ttl := c.options().NegativeTTL
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	s := c.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[id]; ok {
+		return // заказ успел прийти из consumer'а
+	}
+	if s.maxMissing > 0 && len(s.missing) >= s.maxMissing {
+		s.deleteExpiredMissing(now)
+		if len(s.missing) >= s.maxMissing {
+			return
+		}
+	}
+	s.missing[id] = now.Add(ttl)
+}
+
+func (c *Cache) IsMissing(id string) bool {
+	s := c.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.missing[id]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(s.missing, id)
+		return false
+	}
+	c.negativeHits.Add(1)
+	return true
 }
 
+// DeleteAllItems подменяет все сегменты пустыми одной атомарной операцией.
 func (c *Cache) DeleteAllItems() {
-	c.mu.Lock()
-	c.m = make(map[string]*Order)
-	c.mu.Unlock()
+	shards := c.newShards()
+	c.shards.Store(&shards)
+}
+
+func (c *Cache) Stats() CacheStats {
+	st := CacheStats{
+		Hits:         c.hits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+		EvictedBytes: c.evictedBytes.Load(),
+		Expired:      c.expired.Load(),
+		NegativeHits: c.negativeHits.Load(),
+	}
+	if t := c.lastWarm.Load(); t != 0 {
+		st.LastWarm = time.Unix(0, t)
+	}
+	for _, s := range c.shardList() {
+		s.mu.Lock()
+		st.Entries += s.ll.Len()
+		st.Bytes += s.bytes
+		st.Negative += len(s.missing)
+		s.mu.Unlock()
+	}
+	return st
+}
+
+// SampleIDs возвращает до n случайных order_uid: примерно поровну из каждого сегмента
+// (начиная со случайного), внутри сегмента порядок задаёт случайный обход map.
+func (c *Cache) SampleIDs(n int) []string {
+	shards := c.shardList()
+	per := ceilDiv(n, len(shards))
+	out := make([]string, 0, n)
+	start := rand.IntN(len(shards))
+	for i := 0; i < len(shards) && len(out) < n; i++ {
+		s := shards[(start+i)%len(shards)]
+		s.mu.Lock()
+		taken := 0
+		for id := range s.m {
+			if taken == per || len(out) == n {
+				break
+			}
+			out = append(out, id)
+			taken++
+		}
+		s.mu.Unlock()
+	}
+	return out
+}
+
+// Orders возвращает живые записи; внутри каждого сегмента — от недавно использованных к давним,
+// то есть в порядке, который ожидает Warm.
+func (c *Cache) Orders() []*Order {
+	now := time.Now()
+	var out []*Order
+	for _, s := range c.shardList() {
+		s.mu.Lock()
+		for el := s.ll.Front(); el != nil; el = el.Next() {
+			if e := el.Value.(*cacheEntry); !e.expired(now) {
+				out = append(out, e.o)
+			}
+		}
+		s.mu.Unlock()
+	}
+	for i, o := range out {
+		out[i] = o.Clone()
+	}
+	return out
+}
+
+// RunJanitor периодически удаляет просроченные записи, пока не отменён ctx.
+// Работает и при выключенном TTL: его можно включить на ходу через SetOptions.
+func (c *Cache) RunJanitor(ctx context.Context, every time.Duration) {
+	if every <= 0 {
+		return
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if opts := c.options(); opts.TTL <= 0 && opts.NegativeTTL <= 0 {
+				continue
+			}
+			for _, s := range c.shardList() {
+				s.deleteExpired()
+			}
+		}
+	}
+}
+
+func (s *cacheShard) deleteExpired() {
+	now := time.Now()
+	s.mu.Lock()
+	for el := s.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if el.Value.(*cacheEntry).expired(now) {
+			s.remove(el)
+			s.c.expired.Add(1)
+		}
+		el = prev
+	}
+	s.deleteExpiredMissing(now)
+	s.mu.Unlock()
+}
+
+func (s *cacheShard) deleteExpiredMissing(now time.Time) {
+	for id, until := range s.missing {
+		if now.After(until) {
+			delete(s.missing, id)
+		}
+	}
+}
+
+// newEntry готовит запись вместе с закодированным JSON (и gzip), чтобы попадания не кодировали заказ заново.
+func (c *Cache) newEntry(o *Order) *cacheEntry {
+	o = o.Clone() // вызывающий может продолжать менять свой экземпляр
+	opts := c.options()
+	e := &cacheEntry{o: o, stored: time.Now(), size: estimateSize(o)}
+	enc, err := encodeOrder(o, opts.Gzip)
+	if err != nil {
+		log.Printf("[CACHE] encode id=%s: %v", o.OrderUID, err)
+	} else {
+		e.enc = enc
+		e.size += int64(len(enc.JSON) + len(enc.Gzip))
+	}
+	if ttl := opts.TTL; ttl > 0 {
+		e.expires = e.stored.Add(ttl)
+	}
+	return e
+}
+
+// set вызывается под s.mu. Запись с более старой версией заказа не заменяет имеющуюся: чтение из БД,
+// сделанное до commit'а consumer'а (заполнение, фоновое обновление, сверка), не должно затереть его запись.
+// warm: новая запись встаёт в хвост LRU, имеющаяся не переносится; иначе запись становится самой свежей.
+func (s *cacheShard) set(e *cacheEntry, warm bool) {
+	o := e.o
+	delete(s.missing, o.OrderUID)
+	if el, ok := s.m[o.OrderUID]; ok {
+		old := el.Value.(*cacheEntry)
+		if o.olderThan(old.o) {
+			return
+		}
+		s.bytes += e.size - old.size
+		s.idx.remove(old.o)
+		s.idx.add(o)
+		el.Value = e
+		if !warm {
+			s.ll.MoveToFront(el)
+		}
+		return
+	}
+	if warm {
+		s.m[o.OrderUID] = s.ll.PushBack(e)
+	} else {
+		s.m[o.OrderUID] = s.ll.PushFront(e)
+	}
+	s.idx.add(o)
+	s.bytes += e.size
+}
+
+// evict вытесняет записи с конца списка, пока сегмент не уложится в лимиты. Вызывается под s.mu.
+func (s *cacheShard) evict() {
+	for s.ll.Len() > 0 && s.overLimit() {
+		el := s.ll.Back()
+		size := el.Value.(*cacheEntry).size
+		s.remove(el)
+		s.c.evictions.Add(1)
+		s.c.evictedBytes.Add(uint64(size))
+	}
+}
+
+func (s *cacheShard) overLimit() bool {
+	return (s.maxEntries > 0 && s.ll.Len() > s.maxEntries) ||
+		(s.maxBytes > 0 && s.bytes > s.maxBytes)
+}
+
+func (s *cacheShard) remove(el *list.Element) {
+	e := s.ll.Remove(el).(*cacheEntry)
+	delete(s.m, e.o.OrderUID)
+	s.idx.remove(e.o)
+	s.bytes -= e.size
+}
+
+func ceilDiv(a, b int) int { return (a + b - 1) / b }
+
+// estimateSize — грубая оценка занимаемой заказом памяти: строки + фиксированные части структур.
+func estimateSize(o *Order) int64 {
+	const (
+		orderBase = 512
+		itemBase  = 128
+	)
+	n := orderBase + len(o.OrderUID) + len(o.TrackNumber) + len(o.Entry) + len(o.Locale) +
+		len(o.InternalSignature) + len(o.CustomerID) + len(o.DeliveryService) + len(o.ShardKey) + len(o.OofShard)
+	d := o.Delivery
+	n += len(d.Name) + len(d.Phone) + len(d.Zip) + len(d.City) + len(d.Address) + len(d.Region) + len(d.Email)
+	p := o.Payment
+	n += len(p.Transaction) + len(p.RequestID) + len(p.Currency) + len(p.Provider) + len(p.Bank)
+	for _, it := range o.Items {
+		n += itemBase + len(it.TrackNumber) + len(it.RID) + len(it.Name) + len(it.Size) + len(it.Brand)
+	}
+	return int64(n)
 }
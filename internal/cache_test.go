@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testOrder — заказ, у которого track_number шапки совпадает с track_number каждого товара;
+// по этому инварианту видно, что читатель получил заказ целиком одной версии.
+func testOrder(id string, v int) *Order {
+	track := fmt.Sprintf("TRACK-%d", v)
+	o := &Order{
+		OrderUID:    id,
+		TrackNumber: track,
+		Entry:       "WBIL",
+		Locale:      "en",
+		CustomerID:  "customer",
+		DateCreated: time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC),
+		Payment:     Payment{Transaction: id, Currency: "USD", Amount: 100 * v, GoodsTotal: 100 * v},
+		Version:     int64(v),
+	}
+	for i := 0; i < 3; i++ {
+		o.Items = append(o.Items, Item{ChrtID: i, TrackNumber: track, Price: 100, TotalPrice: 100})
+	}
+	return o
+}
+
+func checkConsistent(t *testing.T, o *Order) {
+	t.Helper()
+	if len(o.Items) != 3 {
+		t.Errorf("id=%s: %d items, want 3", o.OrderUID, len(o.Items))
+		return
+	}
+	for i, it := range o.Items {
+		if it.TrackNumber != o.TrackNumber {
+			t.Errorf("id=%s: items[%d].track_number=%q, order track_number=%q", o.OrderUID, i, it.TrackNumber, o.TrackNumber)
+		}
+	}
+}
+
+// Запускать с -race: читатели меняют полученные копии, пока писатели перезаписывают те же ключи.
+func TestCacheConcurrentReadersAndWriters(t *testing.T) {
+	c := NewCache(CacheOptions{Shards: 4, Gzip: true})
+	ids := []string{"a", "b", "c", "d"}
+	const versions = 200
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for v := 1; v <= versions; v++ {
+				o := testOrder(id, v)
+				c.Set(o)
+				// вызывающий продолжает менять свой экземпляр после Set
+				o.TrackNumber = "caller"
+				o.Items[0].TrackNumber = "caller"
+			}
+		}(id)
+	}
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				id := ids[i%len(ids)]
+				o, ok := c.Get(id)
+				if !ok {
+					continue
+				}
+				checkConsistent(t, o)
+				o.TrackNumber = "reader"
+				o.Items[1].TrackNumber = "reader"
+				o.Items = append(o.Items[:1], Item{TrackNumber: "reader"})
+				if co, ok := c.GetEncoded(id); ok {
+					checkConsistent(t, co.Order)
+					co.Order.Items[2].TrackNumber = "reader"
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		o, ok := c.Get(id)
+		if !ok {
+			t.Fatalf("id=%s: not cached", id)
+		}
+		checkConsistent(t, o)
+		if want := fmt.Sprintf("TRACK-%d", versions); o.TrackNumber != want || o.Version != versions {
+			t.Errorf("id=%s: got track_number=%q version=%d, want %q version=%d", id, o.TrackNumber, o.Version, want, versions)
+		}
+	}
+}
+
+func TestCacheOrdersReturnsCopies(t *testing.T) {
+	c := NewCache(CacheOptions{})
+	c.Warm([]*Order{testOrder("a", 1)})
+	for _, o := range c.Orders() {
+		o.Items[0].TrackNumber = "mutated"
+	}
+	o, _ := c.Get("a")
+	checkConsistent(t, o)
+}
+
+const benchOrders = 10000
+
+func benchCache(shards int) (*Cache, []*Order) {
+	c := NewCache(CacheOptions{Shards: shards})
+	orders := make([]*Order, benchOrders)
+	for i := range orders {
+		orders[i] = testOrder(fmt.Sprintf("order-%d", i), 1)
+	}
+	c.Warm(orders)
+	return c, orders
+}
+
+// Shards=1 — прежний кэш под одной блокировкой, Shards=16 — сегментированный.
+func BenchmarkCacheGetParallel(b *testing.B) {
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("Shards=%d", shards), func(b *testing.B) {
+			c, orders := benchCache(shards)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					c.Get(orders[i%len(orders)].OrderUID)
+					i++
+				}
+			})
+		})
+	}
+}
+
+// Смешанная нагрузка: на 100 операций 90 Get, 9 Set и один Warm пачки из 100 заказов.
+func BenchmarkCacheMixedParallel(b *testing.B) {
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("Shards=%d", shards), func(b *testing.B) {
+			c, orders := benchCache(shards)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					o := orders[i%len(orders)]
+					switch op := i % 100; {
+					case op == 0:
+						start := i % (len(orders) - 100)
+						c.Warm(orders[start : start+100])
+					case op < 10:
+						c.Set(o)
+					default:
+						c.Get(o.OrderUID)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+// Чтение из БД, начатое до записи consumer'а, не должно затереть более новую версию в кэше.
+func TestCacheKeepsNewerVersion(t *testing.T) {
+	c := NewCache(CacheOptions{Shards: 4})
+	c.Set(testOrder("a", 2))
+	c.Set(testOrder("a", 1))
+	c.Warm([]*Order{testOrder("a", 1)})
+	if o, _ := c.Get("a"); o.Version != 2 {
+		t.Fatalf("version = %d, want 2", o.Version)
+	}
+
+	same := testOrder("a", 2)
+	same.Entry = "refreshed"
+	c.Set(same)
+	if o, _ := c.Get("a"); o.Entry != "refreshed" {
+		t.Errorf("equal version was not replaced: entry=%q", o.Entry)
+	}
+}
+
+// Прогрев порциями по warmChunk (как Warmer.Run) при WARM_N больше лимита: остаются самые важные заказы.
+func TestCacheWarmInChunksKeepsMostImportant(t *testing.T) {
+	const warmN, maxEntries = 3 * warmChunk, warmChunk + warmChunk/2
+	c := NewCache(CacheOptions{MaxEntries: maxEntries, Shards: 1})
+	read := testOrder("read-before-warm", 1)
+	c.Set(read)
+
+	list := make([]*Order, warmN) // по убыванию важности
+	for i := range list {
+		list[i] = testOrder(fmt.Sprintf("id-%03d", i), 1)
+	}
+	for i := 0; i < len(list); i += warmChunk {
+		c.Warm(list[i:min(i+warmChunk, len(list))])
+	}
+
+	if _, ok := c.Get(read.OrderUID); !ok {
+		t.Error("warm-up evicted an order read before it")
+	}
+	for i, o := range list {
+		_, ok := c.Get(o.OrderUID)
+		if want := i < maxEntries-1; ok != want {
+			t.Fatalf("%s (rank %d): cached=%t, want %t", o.OrderUID, i, ok, want)
+		}
+	}
+}
+
+// Служебные чтения (сверка, инвалидация) не считаются в статистике и не спасают запись от вытеснения.
+func TestCachePeekDoesNotTouchStatsOrLRU(t *testing.T) {
+	c := NewCache(CacheOptions{MaxEntries: 2, Shards: 1})
+	c.Set(testOrder("old", 1))
+	c.Set(testOrder("new", 1))
+
+	if o, ok := c.Peek("old"); !ok || o.OrderUID != "old" {
+		t.Fatal("Peek missed a cached order")
+	}
+	if _, ok := c.Peek("missing"); ok {
+		t.Fatal("Peek hit a missing order")
+	}
+	if st := c.Stats(); st.Hits != 0 || st.Misses != 0 {
+		t.Errorf("Peek changed stats: hits=%d misses=%d", st.Hits, st.Misses)
+	}
+
+	c.Set(testOrder("third", 1))
+	if _, ok := c.Peek("old"); ok {
+		t.Error("peeked order was moved to the LRU front and survived eviction")
+	}
+	if _, ok := c.Peek("new"); !ok {
+		t.Error("evicted the wrong order")
+	}
+}
@@ -1,10 +1,13 @@
 package internal
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
-	"strconv"
-	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,44 +15,300 @@ import (
 type Config struct {
 	Addr         string
 	PGURL        string
+	PGPool       PoolOptions
+	PGStartup    time.Duration // сколько ждать БД при старте
 	Brokers      []string
 	Topic        string
 	Group        string
+	Kafka        KafkaSecurity
+	Retry        RetryOptions
+	Batch        BatchOptions
+	DLQTopic     string // dead-letter топик; пусто — отклонённые сообщения только логируются
 	WarmN        int
 	CacheEnabled bool
+
+	CacheMaxEntries int
+	CacheMaxBytes   int64
+	CacheTTL        time.Duration
+	CacheJanitor    time.Duration
+	CacheShards     int
+	CacheNegTTL     time.Duration
+	CacheNegMax     int
+	CacheGzip       bool
+
+	// CacheSoftTTL — возраст записи, после которого она отдаётся как cache-stale и обновляется в фоне; 0 — выключено.
+	CacheSoftTTL     time.Duration
+	CacheRefreshConc int
+
+	CacheBackend  string // memory | redis | tiered
+	RedisAddr     string
+	RedisPassword string
+	RedisPrefix   string
+
+	// двухуровневый кэш (CACHE_BACKEND=tiered): небольшой L1 в памяти перед Redis (L2)
+	CacheL1MaxEntries int
+	CacheL1MaxBytes   int64
+	L1ReadThrough     bool
+	L1WriteThrough    bool
+	L2ReadThrough     bool
+	L2WriteThrough    bool
+
+	// CacheInvalidation — слушать уведомления об изменениях заказов от других реплик (LISTEN/NOTIFY).
+	CacheInvalidation bool
+
+	WarmStrategy      string
+	WarmBudget        time.Duration
+	WarmCreatedWindow time.Duration
+	WarmFile          string
+
+	// AccessTracking — учитывать чтения заказов в order_access (нужно для WARM_STRATEGY=popular).
+	AccessTracking bool
+	AccessFlush    time.Duration
+
+	// VerifyInterval — период сверки кэша с БД; 0 — выключено.
+	VerifyInterval time.Duration
+	VerifySample   int
+	VerifyMode     string // repair | evict
+
+	// AdminAddr — отдельный адрес служебных ручек кэша; пусто — выключены. Требуют AdminToken.
+	AdminAddr  string
+	AdminToken string
+
+	// SnapshotPath — файл снимка кэша (сохраняется при остановке, читается при старте); пусто — выключено.
+	SnapshotPath   string
+	SnapshotMaxAge time.Duration
+
+	LogLevel string // debug | info | error
+
+	// RateLimit — предел запросов в секунду к HTTP API (токен-бакет на весь процесс); 0 — без ограничения.
+	RateLimit int
+	RateBurst int
+
+	shown map[string]any // эффективные значения для Print
 }
 
-func Env() Config {
+// Env загружает конфигурацию из переменных окружения (и .env) поверх файла path;
+// пустой path — файл из CONFIG_FILE, если она задана.
+func Env(path string) (Config, error) {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
 		log.Printf("Error loading .env file: %v", err)
 	}
-	get := func(k string) string {
-		v := os.Getenv(k)
-		if v == "" {
-			log.Fatalf("Environment variable %s is not set", k)
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	return Load(path)
+}
+
+// Load читает файл конфигурации path (пусто — без файла), применяет поверх него переменные окружения
+// и проверяет результат. Возвращает все найденные ошибки разом.
+func Load(path string) (Config, error) {
+	l := &loader{seen: map[string]any{}}
+	if path != "" {
+		f, err := readConfigFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("config file: %w", err)
+		}
+		l.file = f
+	}
+	c := Config{
+		Addr:  l.required("HTTP_ADDR"),
+		PGURL: l.pgURL("PG_URL"),
+		PGPool: PoolOptions{
+			MaxConns:         l.intIn("PG_MAX_CONNS", 0, 0, math.MaxInt32),
+			MinConns:         l.intIn("PG_MIN_CONNS", 0, 0, math.MaxInt32),
+			MaxConnIdleTime:  l.duration("PG_MAX_CONN_IDLE_TIME", 30*time.Minute),
+			MaxConnLifetime:  l.duration("PG_MAX_CONN_LIFETIME", time.Hour),
+			StatementTimeout: l.duration("PG_STATEMENT_TIMEOUT", 0),
+		},
+		PGStartup: l.duration("PG_STARTUP_TIMEOUT", time.Minute),
+		Brokers:   l.brokers("KAFKA_BROKERS"),
+		Topic:     l.required("KAFKA_TOPIC"),
+		Group:     l.required("KAFKA_GROUP_ID"),
+		Kafka:     l.kafkaSecurity(),
+		DLQTopic:  l.str("KAFKA_DLQ_TOPIC", ""),
+		Batch: BatchOptions{
+			Size:   l.intIn("CONSUMER_BATCH_SIZE", 100, 1, 10000),
+			Linger: l.duration("CONSUMER_BATCH_LINGER", 50*time.Millisecond),
+		},
+		Retry: RetryOptions{
+			Max:      l.intIn("CONSUMER_RETRY_MAX", 5, 0, 1000),
+			Initial:  l.duration("CONSUMER_RETRY_BACKOFF", 200*time.Millisecond),
+			MaxDelay: l.duration("CONSUMER_RETRY_MAX_BACKOFF", 10*time.Second),
+		},
+		WarmN:        l.intIn("WARM_N", 1000, 0, math.MaxInt32),
+		CacheEnabled: l.boolean("CACHE_ENABLED", true),
+
+		CacheMaxEntries: l.intIn("CACHE_MAX_ENTRIES", 100000, 0, math.MaxInt),
+		CacheMaxBytes:   int64(l.intIn("CACHE_MAX_BYTES", 256<<20, 0, math.MaxInt)),
+		CacheTTL:        l.duration("CACHE_TTL", 10*time.Minute),
+		CacheJanitor:    l.duration("CACHE_JANITOR_INTERVAL", time.Minute),
+		CacheShards:     l.intIn("CACHE_SHARDS", 16, 1, 4096),
+		CacheNegTTL:     l.duration("CACHE_NEGATIVE_TTL", 30*time.Second),
+		CacheNegMax:     l.intIn("CACHE_NEGATIVE_MAX", 100000, 0, math.MaxInt),
+		CacheGzip:       l.boolean("CACHE_GZIP", true),
+
+		CacheSoftTTL:     l.duration("CACHE_SOFT_TTL", 0),
+		CacheRefreshConc: l.intIn("CACHE_REFRESH_CONCURRENCY", 4, 1, 1024),
+
+		CacheBackend:  l.oneOf("CACHE_BACKEND", "memory", "memory", "redis", "tiered"),
+		RedisAddr:     l.str("REDIS_ADDR", "localhost:6379"),
+		RedisPassword: l.secret("REDIS_PASSWORD"),
+		RedisPrefix:   l.str("REDIS_KEY_PREFIX", "order:"),
+
+		CacheL1MaxEntries: l.intIn("CACHE_L1_MAX_ENTRIES", 10000, 0, math.MaxInt),
+		CacheL1MaxBytes:   int64(l.intIn("CACHE_L1_MAX_BYTES", 32<<20, 0, math.MaxInt)),
+		L1ReadThrough:     l.boolean("CACHE_L1_READ_THROUGH", true),
+		L1WriteThrough:    l.boolean("CACHE_L1_WRITE_THROUGH", true),
+		L2ReadThrough:     l.boolean("CACHE_L2_READ_THROUGH", true),
+		L2WriteThrough:    l.boolean("CACHE_L2_WRITE_THROUGH", true),
+
+		CacheInvalidation: l.boolean("CACHE_INVALIDATION", true),
+
+		WarmStrategy:      l.oneOf("WARM_STRATEGY", WarmRecent, WarmRecent, WarmPopular, WarmCreated, WarmFile),
+		WarmBudget:        l.duration("WARM_BUDGET", 30*time.Second),
+		WarmCreatedWindow: l.duration("WARM_CREATED_WINDOW", 24*time.Hour),
+		WarmFile:          l.str("WARM_FILE", ""),
+
+		AccessFlush: l.duration("ACCESS_FLUSH_INTERVAL", 30*time.Second),
+
+		VerifyInterval: l.duration("VERIFY_INTERVAL", 5*time.Minute),
+		VerifySample:   l.intIn("VERIFY_SAMPLE", 100, 1, 100000),
+		VerifyMode:     l.oneOf("VERIFY_MODE", VerifyRepair, VerifyRepair, VerifyEvict),
+
+		AdminAddr:  l.str("ADMIN_ADDR", ""),
+		AdminToken: l.secret("ADMIN_TOKEN"),
+
+		SnapshotPath:   l.str("CACHE_SNAPSHOT_PATH", ""),
+		SnapshotMaxAge: l.duration("CACHE_SNAPSHOT_MAX_AGE", time.Hour),
+
+		LogLevel: l.oneOf("LOG_LEVEL", LogInfo, LogDebug, LogInfo, LogError),
+
+		RateLimit: l.intIn("HTTP_RATE_LIMIT", 0, 0, math.MaxInt32),
+		RateBurst: l.intIn("HTTP_RATE_BURST", 100, 1, math.MaxInt32),
+	}
+	c.AccessTracking = l.boolean("ACCESS_TRACKING", c.WarmStrategy == WarmPopular)
+
+	if c.Addr != "" {
+		l.hostPort("HTTP_ADDR", c.Addr)
+	}
+	if c.DLQTopic != "" && c.DLQTopic == c.Topic {
+		l.errorf("KAFKA_DLQ_TOPIC", "must differ from KAFKA_TOPIC")
+	}
+	if c.Retry.MaxDelay < c.Retry.Initial {
+		l.errorf("CONSUMER_RETRY_MAX_BACKOFF", "%s must not be less than CONSUMER_RETRY_BACKOFF=%s", c.Retry.MaxDelay, c.Retry.Initial)
+	}
+	if p := c.PGPool; p.MaxConns > 0 && p.MinConns > p.MaxConns {
+		l.errorf("PG_MIN_CONNS", "%d must not exceed PG_MAX_CONNS=%d", p.MinConns, p.MaxConns)
+	}
+	if c.CacheBackend != "memory" {
+		l.hostPort("REDIS_ADDR", c.RedisAddr)
+	}
+	if c.CacheSoftTTL > 0 && c.CacheTTL > 0 && c.CacheSoftTTL >= c.CacheTTL {
+		l.errorf("CACHE_SOFT_TTL", "%s must be less than CACHE_TTL=%s", c.CacheSoftTTL, c.CacheTTL)
+	}
+	if c.CacheJanitor == 0 {
+		l.errorf("CACHE_JANITOR_INTERVAL", "must be positive")
+	}
+	if c.AccessTracking && c.AccessFlush == 0 {
+		l.errorf("ACCESS_FLUSH_INTERVAL", "must be positive when ACCESS_TRACKING is on")
+	}
+	if c.WarmStrategy == WarmFile && c.WarmFile == "" {
+		l.errorf("WARM_FILE", "is required for WARM_STRATEGY=%s", WarmFile)
+	}
+	if c.AdminAddr != "" {
+		l.hostPort("ADMIN_ADDR", c.AdminAddr)
+		if c.AdminToken == "" {
+			l.errorf("ADMIN_TOKEN", "is required when ADMIN_ADDR is set")
 		}
-		return v
 	}
-	return Config{
-		Addr:         get("HTTP_ADDR"),
-		PGURL:        get("PG_URL"),
-		Brokers:      strings.Split(get("KAFKA_BROKERS"), ","),
-		Topic:        get("KAFKA_TOPIC"),
-		Group:        get("KAFKA_GROUP_ID"),
-		WarmN:        1000,
-		CacheEnabled: loadCache(),
+	c.shown = l.seen
+	if len(l.errs) > 0 {
+		return c, fmt.Errorf("invalid configuration:\n%w", errors.Join(l.errs...))
+	}
+	return c, nil
+}
+
+// LoadKafkaSecurity читает только настройки TLS/SASL для Kafka (для утилит вроде cmd/producer).
+func LoadKafkaSecurity(path string) (KafkaSecurity, error) {
+	l := &loader{seen: map[string]any{}}
+	if path != "" {
+		f, err := readConfigFile(path)
+		if err != nil {
+			return KafkaSecurity{}, fmt.Errorf("config file: %w", err)
+		}
+		l.file = f
 	}
+	s := l.kafkaSecurity()
+	return s, errors.Join(l.errs...)
 }
 
-func loadCache() bool {
-	v := os.Getenv("CACHE_ENABLED")
-	on, err := strconv.ParseBool(v) // "1"/"true" -> true
-	if err != nil {
-		log.Printf("Cache is not enabled (converting error): %v", err)
+func (l *loader) kafkaSecurity() KafkaSecurity {
+	s := KafkaSecurity{
+		TLS:        l.boolean("KAFKA_TLS", false),
+		CAFile:     l.str("KAFKA_TLS_CA_FILE", ""),
+		CertFile:   l.str("KAFKA_TLS_CERT_FILE", ""),
+		KeyFile:    l.str("KAFKA_TLS_KEY_FILE", ""),
+		ServerName: l.str("KAFKA_TLS_SERVER_NAME", ""),
+
+		SASLMechanism: l.str("KAFKA_SASL_MECHANISM", ""),
+		SASLUser:      l.str("KAFKA_SASL_USERNAME", ""),
+		SASLPassword:  l.secret("KAFKA_SASL_PASSWORD"),
 	}
-	if v == "" {
-		on = true
+	l.errs = append(l.errs, s.check()...)
+	return s
+}
+
+// Print пишет эффективную конфигурацию в формате файла конфигурации; пароли и токены скрыты.
+func (c Config) Print(w io.Writer) error {
+	return printSettings(w, c.shown)
+}
+
+// CacheOptions — лимиты кэша из конфига.
+func (c Config) CacheOptions() CacheOptions {
+	return CacheOptions{
+		MaxEntries:  c.CacheMaxEntries,
+		MaxBytes:    c.CacheMaxBytes,
+		TTL:         c.CacheTTL,
+		Shards:      c.CacheShards,
+		NegativeTTL: c.CacheNegTTL,
+		NegativeMax: c.CacheNegMax,
+		Gzip:        c.CacheGzip,
+	}
+}
+
+// ConsumerOptions — параметры consumer'а из конфига.
+func (c Config) ConsumerOptions() ConsumerOptions {
+	return ConsumerOptions{Brokers: c.Brokers, Topic: c.Topic, Group: c.Group, Security: c.Kafka, Retry: c.Retry, Batch: c.Batch}
+}
+
+// L1Options — лимиты локального уровня двухуровневого кэша (CACHE_BACKEND=tiered).
+func (c Config) L1Options() CacheOptions {
+	o := c.CacheOptions()
+	o.MaxEntries, o.MaxBytes = c.CacheL1MaxEntries, c.CacheL1MaxBytes
+	return o
+}
+
+func (c Config) RedisOptions() RedisOptions {
+	return RedisOptions{Addr: c.RedisAddr, Password: c.RedisPassword, Prefix: c.RedisPrefix, TTL: c.CacheTTL}
+}
+
+func (c Config) TieredOptions() TieredOptions {
+	return TieredOptions{
+		L1ReadThrough:  c.L1ReadThrough,
+		L1WriteThrough: c.L1WriteThrough,
+		L2ReadThrough:  c.L2ReadThrough,
+		L2WriteThrough: c.L2WriteThrough,
+	}
+}
+
+// WarmOptions — параметры прогрева из конфига.
+func (c Config) WarmOptions() WarmOptions {
+	return WarmOptions{
+		Strategy:      c.WarmStrategy,
+		N:             c.WarmN,
+		Budget:        c.WarmBudget,
+		CreatedWindow: c.WarmCreatedWindow,
+		File:          c.WarmFile,
 	}
-	return on
 }
@@ -3,41 +3,80 @@ package internal
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"sync/atomic"
+	"time"
 
 	"github.com/IBM/sarama"
 )
 
+// ConsumerOptions — подключение consumer'а к Kafka и политика повторов записи в БД.
+type ConsumerOptions struct {
+	Brokers  []string
+	Topic    string
+	Group    string
+	Security KafkaSecurity
+	Retry    RetryOptions
+	Batch    BatchOptions
+}
+
+// BatchOptions — сколько сообщений партиции записывать в БД одной транзакцией и сколько ждать
+// добора пачки после первого сообщения. Size 1 — обработка по одному.
+type BatchOptions struct {
+	Size   int
+	Linger time.Duration
+}
+
+// ConsumerStats — снимок счётчиков consumer'а.
+type ConsumerStats struct {
+	Saved        uint64 `json:"saved"`
+	Stale        uint64 `json:"stale"` // версия не новее сохранённой, заказ пропущен
+	DeadLettered uint64 `json:"dead_lettered"`
+}
+
+type consumerCounters struct {
+	saved        atomic.Uint64
+	stale        atomic.Uint64
+	deadLettered atomic.Uint64
+}
+
 type Consumer struct {
 	group sarama.ConsumerGroup
-	topic string
-	cache *Cache
+	opts  ConsumerOptions
+	cache OrderCache
 	repo  *Repo
+	dlq   *DeadLetters // nil — отклонённые сообщения только логируются
+	stats consumerCounters
 }
 
-func NewConsumer(brokers []string, topic, groupID string, cache *Cache, repo *Repo) *Consumer {
+func NewConsumer(opts ConsumerOptions, cache OrderCache, repo *Repo, dlq *DeadLetters) (*Consumer, error) {
 	cfg := sarama.NewConfig()
 	cfg.Version = sarama.V2_1_0_0
 	cfg.Consumer.Return.Errors = true
 	// cfg.Consumer.Offsets.Initial = sarama.OffsetNewest
 	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	if err := opts.Security.Apply(cfg); err != nil {
+		return nil, fmt.Errorf("kafka security: %w", err)
+	}
 
-	cg, err := sarama.NewConsumerGroup(brokers, groupID, cfg)
+	cg, err := sarama.NewConsumerGroup(opts.Brokers, opts.Group, cfg)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("kafka consumer group: %w", err)
 	}
 	return &Consumer{
 		group: cg,
-		topic: topic,
+		opts:  opts,
 		cache: cache,
 		repo:  repo,
-	}
+		dlq:   dlq,
+	}, nil
 }
 
 func (c *Consumer) Start(ctx context.Context) error {
-	handler := &cgHandler{cache: c.cache, repo: c.repo}
+	handler := &cgHandler{cache: c.cache, repo: c.repo, dlq: c.dlq, retry: c.opts.Retry, batch: c.opts.Batch, stats: &c.stats}
 	for {
-		if err := c.group.Consume(ctx, []string{c.topic}, handler); err != nil {
+		if err := c.group.Consume(ctx, []string{c.opts.Topic}, handler); err != nil {
 			log.Printf("Consume error: %v", err)
 		}
 		if ctx.Err() != nil {
@@ -48,29 +87,203 @@ func (c *Consumer) Start(ctx context.Context) error {
 
 func (c *Consumer) Close() error { return c.group.Close() }
 
+func (c *Consumer) Stats() ConsumerStats {
+	return ConsumerStats{
+		Saved:        c.stats.saved.Load(),
+		Stale:        c.stats.stale.Load(),
+		DeadLettered: c.stats.deadLettered.Load(),
+	}
+}
+
 type cgHandler struct {
-	cache *Cache
+	cache OrderCache
 	repo  *Repo
+	dlq   *DeadLetters
+	retry RetryOptions
+	batch BatchOptions
+	stats *consumerCounters
 }
 
 func (h *cgHandler) Setup(sarama.ConsumerGroupSession) error { return nil }
 
 func (h *cgHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
 
-// Получаем партицию сообщений и обрабатываем их по одному в цикле
+// ConsumeClaim копит сообщения партиции в пачку (до BatchSize или BatchLinger с первого сообщения)
+// и обрабатывает её целиком.
 func (h *cgHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for msg := range claim.Messages() {
-		var o Order
-		if err := json.Unmarshal(msg.Value, &o); err != nil || o.OrderUID == "" {
-			sess.MarkMessage(msg, "invalid-json")
+	size := max(h.batch.Size, 1)
+	batch := make([]*sarama.ConsumerMessage, 0, size)
+	linger := time.NewTimer(h.batch.Linger)
+	linger.Stop()
+	defer linger.Stop()
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return h.flush(sess, batch)
+			}
+			batch = append(batch, msg)
+			if len(batch) < size {
+				if len(batch) == 1 {
+					linger.Reset(h.batch.Linger)
+				}
+				continue
+			}
+		case <-linger.C:
+		case <-sess.Context().Done():
+			return nil // offset'ы непомеченной пачки не зафиксированы, сообщения придут снова
+		}
+		linger.Stop()
+		if err := h.flush(sess, batch); err != nil {
+			return err
+		}
+		batch = batch[:0]
+	}
+}
+
+// flush обрабатывает пачку: непрошедшие разбор и проверку уходят в dead-letter топик, остальные
+// записываются в БД одной транзакцией. Offset'ы помечаются только после commit; при ошибке пачки
+// сообщения обрабатываются по одному, чтобы отделить проблемную запись.
+func (h *cgHandler) flush(sess sarama.ConsumerGroupSession, batch []*sarama.ConsumerMessage) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	orders := make([]*Order, len(batch)) // nil — сообщение уже в dead-letter топике
+	var valid []*Order
+	for i, msg := range batch {
+		o, reason, err := decodeOrder(msg)
+		if err != nil {
+			if err := h.dlq.Send(msg, reason, err); err != nil {
+				log.Printf("[DLQ] %v", err)
+				return err
+			}
+			h.stats.deadLettered.Add(1)
 			continue
 		}
-		if err := h.repo.Upsert(sess.Context(), &o); err != nil {
+		orders[i] = o
+		valid = append(valid, o)
+	}
+
+	applied, err := h.repo.UpsertBatch(sess.Context(), valid)
+	if err == nil {
+		for i, o := range valid {
+			h.saved(o, applied[i])
+		}
+		// пометка последнего сообщения фиксирует всю пачку
+		sess.MarkMessage(batch[len(batch)-1], "")
+		return nil
+	}
+	if sess.Context().Err() != nil {
+		return nil
+	}
+	if len(valid) > 1 {
+		log.Printf("[CONSUMER] batch of %d orders failed, falling back to one by one: %v", len(valid), err)
+	}
+	for i, msg := range batch {
+		if orders[i] == nil {
+			sess.MarkMessage(msg, "rejected")
 			continue
 		}
-		h.cache.Set(&o)
-		log.Printf("[CONSUMED] id=%s -> saved to DB and cache", o.OrderUID)
-		sess.MarkMessage(msg, "")
+		if err := h.store(sess, msg, orders[i]); err != nil {
+			return err
+		}
+		if sess.Context().Err() != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// decodeOrder разбирает и проверяет заказ; при ошибке возвращает причину для dead-letter топика.
+func decodeOrder(msg *sarama.ConsumerMessage) (*Order, string, error) {
+	var o Order
+	if err := json.Unmarshal(msg.Value, &o); err != nil {
+		return nil, DLQInvalidJSON, err
+	}
+	if err := o.Validate(); err != nil {
+		return nil, DLQValidation, err
+	}
+	if o.Version == 0 {
+		o.Version, o.VersionSeq = messageVersion(msg)
+	}
+	return &o, "", nil
+}
+
+// messageVersion — версия заказа без поля version: время сообщения Kafka (CreateTime или LogAppendTime)
+// в микросекундах, при его отсутствии — время получения, и offset. У Kafka время с точностью до
+// миллисекунды, а сообщения одного ключа идут в одну партицию, поэтому offset упорядочивает
+// обновления, отправленные в одну миллисекунду.
+func messageVersion(msg *sarama.ConsumerMessage) (int64, int64) {
+	if ts := msg.Timestamp.UnixMicro(); ts > 0 {
+		return ts, msg.Offset
+	}
+	return time.Now().UnixMicro(), msg.Offset
+}
+
+// store пишет один заказ с повторами и помечает сообщение; неудачная запись уходит в dead-letter топик.
+func (h *cgHandler) store(sess sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, o *Order) error {
+	applied, err := h.upsert(sess.Context(), o)
+	if err != nil {
+		if sess.Context().Err() != nil {
+			return nil // ребалансировка или остановка: offset не помечен, сообщение придёт снова
+		}
+		reason := DLQPermanent
+		if IsTransient(err) {
+			reason = DLQRetryExhausted
+		}
+		return h.reject(sess, msg, reason, err)
+	}
+	h.saved(o, applied)
+	sess.MarkMessage(msg, "")
+	return nil
+}
+
+// saved обновляет кэш записанным заказом; устаревший заказ только учитывается в счётчике.
+func (h *cgHandler) saved(o *Order, applied bool) {
+	if !applied {
+		h.stats.stale.Add(1)
+		debugf("[CONSUMED] id=%s version=%d/%d -> stale, skipped", o.OrderUID, o.Version, o.VersionSeq)
+		return
+	}
+	h.stats.saved.Add(1)
+	h.cache.Set(o)
+	infof("[CONSUMED] id=%s -> saved to DB and cache", o.OrderUID)
+}
+
+// upsert пишет заказ в БД, повторяя временные ошибки с экспоненциальной задержкой. Повторы идут
+// внутри ConsumeClaim, поэтому следующие сообщения партиции ждут и порядок сохраняется.
+// false — заказ устарел и не записан.
+func (h *cgHandler) upsert(ctx context.Context, o *Order) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		applied, err := h.repo.Upsert(ctx, o)
+		if !h.retryable(err, attempt) {
+			return applied, err
+		}
+		log.Printf("[CONSUMER] upsert id=%s attempt %d: %v", o.OrderUID, attempt, err)
+		if !h.retry.sleep(ctx, attempt) {
+			return false, ctx.Err()
+		}
+	}
+}
+
+// retryable решает, повторять ли запись после попытки attempt (с 1). Временные ошибки повторяются
+// CONSUMER_RETRY_MAX раз, а без dead-letter топика — бесконечно (до ребалансировки или остановки):
+// иначе после исчерпания попыток offset был бы помечен, а заказ потерян.
+func (h *cgHandler) retryable(err error, attempt int) bool {
+	if err == nil || !IsTransient(err) {
+		return false
+	}
+	return h.dlq == nil || attempt <= h.retry.Max
+}
+
+// reject отправляет сообщение в dead-letter топик и помечает его обработанным. Если отправить не удалось,
+// offset не помечается, а ошибка завершает сессию: после ребалансировки сообщение придёт снова.
+func (h *cgHandler) reject(sess sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, reason string, cause error) error {
+	if err := h.dlq.Send(msg, reason, cause); err != nil {
+		log.Printf("[DLQ] %v", err)
+		return err
 	}
+	h.stats.deadLettered.Add(1)
+	sess.MarkMessage(msg, reason)
 	return nil
 }
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func testMessage(t *testing.T, o *Order, offset int64, ts time.Time) *sarama.ConsumerMessage {
+	t.Helper()
+	b, err := json.Marshal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sarama.ConsumerMessage{Topic: "orders", Offset: offset, Timestamp: ts, Key: []byte(o.OrderUID), Value: b}
+}
+
+func TestDecodeOrderVersionFromMessage(t *testing.T) {
+	ts := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	o := validItem(100, 0, 100)
+	o.Version = 0
+
+	// два обновления в одну миллисекунду различаются offset'ом
+	first, _, err := decodeOrder(testMessage(t, o, 41, ts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := decodeOrder(testMessage(t, o, 42, ts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != ts.UnixMicro() || first.VersionSeq != 41 {
+		t.Errorf("version = %d/%d, want %d/41", first.Version, first.VersionSeq, ts.UnixMicro())
+	}
+	if !first.olderThan(second) || second.olderThan(first) {
+		t.Errorf("offset 41 must be older than offset 42 at the same timestamp")
+	}
+
+	// явная версия не заменяется и не зависит от offset'а
+	o.Version = 7
+	explicit, _, err := decodeOrder(testMessage(t, o, 43, ts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explicit.Version != 7 || explicit.VersionSeq != 0 {
+		t.Errorf("explicit version = %d/%d, want 7/0", explicit.Version, explicit.VersionSeq)
+	}
+}
+
+func TestRetryableWithoutDeadLetters(t *testing.T) {
+	transient, permanent := io.ErrUnexpectedEOF, errors.New("constraint violation")
+	withDLQ := &cgHandler{dlq: &DeadLetters{}, retry: RetryOptions{Max: 5}}
+	noDLQ := &cgHandler{retry: RetryOptions{Max: 5}}
+
+	if !withDLQ.retryable(transient, 5) || withDLQ.retryable(transient, 6) {
+		t.Error("with a dead-letter topic transient errors must be retried CONSUMER_RETRY_MAX times")
+	}
+	// без dead-letter топика исчерпание попыток пометило бы offset незаписанного заказа
+	if !noDLQ.retryable(transient, 1000) {
+		t.Error("without a dead-letter topic transient errors must be retried until the session ends")
+	}
+	for _, h := range []*cgHandler{withDLQ, noDLQ} {
+		if h.retryable(nil, 1) || h.retryable(permanent, 1) {
+			t.Error("nil and permanent errors must not be retried")
+		}
+	}
+}
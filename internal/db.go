@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	pingMinBackoff = 500 * time.Millisecond
+	pingMaxBackoff = 10 * time.Second
+	pingTimeout    = 5 * time.Second
+)
+
+// PoolOptions — настройки пула соединений с PostgreSQL. Нулевое значение поля — значение pgxpool по умолчанию.
+type PoolOptions struct {
+	MaxConns        int
+	MinConns        int
+	MaxConnIdleTime time.Duration
+	MaxConnLifetime time.Duration
+
+	// StatementTimeout — statement_timeout сессии; 0 — без ограничения.
+	StatementTimeout time.Duration
+}
+
+// OpenPool создаёт пул. Соединения открываются лениво, доступность БД проверяет WaitForDB.
+func OpenPool(ctx context.Context, pgURL string, opts PoolOptions) (*pgxpool.Pool, error) {
+	pc, err := pgxpool.ParseConfig(pgURL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxConns > 0 {
+		pc.MaxConns = int32(opts.MaxConns)
+	}
+	if opts.MinConns > 0 {
+		pc.MinConns = int32(opts.MinConns)
+	}
+	if opts.MaxConnIdleTime > 0 {
+		pc.MaxConnIdleTime = opts.MaxConnIdleTime
+	}
+	if opts.MaxConnLifetime > 0 {
+		pc.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if opts.StatementTimeout > 0 {
+		pc.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10)
+	}
+	return pgxpool.NewWithConfig(ctx, pc)
+}
+
+// WaitForDB пингует БД с экспоненциальной задержкой, пока она не ответит.
+// timeout ограничивает общее ожидание (0 — пока не отменён ctx).
+func WaitForDB(ctx context.Context, pool *pgxpool.Pool, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	backoff := pingMinBackoff
+	for attempt := 1; ; attempt++ {
+		pctx, cancel := context.WithTimeout(ctx, pingTimeout)
+		err := pool.Ping(pctx)
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("[DB] reachable after %d attempts", attempt)
+			}
+			return nil
+		}
+		log.Printf("[DB] ping: %v, retry in %s", err, backoff)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database is not reachable: %w", err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, pingMaxBackoff)
+	}
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Причины отправки сообщения в dead-letter топик (заголовок dlq-reason).
+const (
+	DLQInvalidJSON    = "invalid-json"      // не разбирается как заказ
+	DLQValidation     = "validation-failed" // заказ не прошёл проверку
+	DLQRetryExhausted = "retry-exhausted"   // запись в БД не удалась после всех попыток
+	DLQPermanent      = "permanent-error"   // БД отвергла заказ (ограничения, ошибки данных), повтор не поможет
+)
+
+// Заголовки, которые DeadLetters добавляет к исходным.
+const (
+	HeaderDLQReason    = "dlq-reason"
+	HeaderDLQError     = "dlq-error"
+	HeaderDLQFields    = "dlq-validation" // JSON-массив FieldError для validation-failed
+	HeaderDLQTopic     = "dlq-source-topic"
+	HeaderDLQPartition = "dlq-source-partition"
+	HeaderDLQOffset    = "dlq-source-offset"
+	HeaderDLQTimestamp = "dlq-timestamp" // время исходного сообщения, RFC 3339
+)
+
+// DeadLetters публикует необработанные сообщения в отдельный топик: исходные ключ, значение и заголовки
+// плюс причина, источник и время. nil *DeadLetters — топик не настроен, сообщения только логируются.
+type DeadLetters struct {
+	prod  sarama.SyncProducer
+	topic string
+}
+
+func NewDeadLetters(brokers []string, topic string, sec KafkaSecurity) (*DeadLetters, error) {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_1_0_0 // заголовки сообщений
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Retry.Max = 3
+	if err := sec.Apply(cfg); err != nil {
+		return nil, fmt.Errorf("kafka security: %w", err)
+	}
+	prod, err := sarama.NewSyncProducer(brokers, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("dead-letter producer: %w", err)
+	}
+	return &DeadLetters{prod: prod, topic: topic}, nil
+}
+
+// Send публикует msg с причиной reason; cause, если есть, попадает в заголовок dlq-error.
+// Ошибка означает, что сообщение не сохранено и его offset нельзя помечать.
+func (d *DeadLetters) Send(msg *sarama.ConsumerMessage, reason string, cause error) error {
+	log.Printf("[DLQ] %s/%d@%d reason=%s: %v", msg.Topic, msg.Partition, msg.Offset, reason, cause)
+	if d == nil {
+		return nil
+	}
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+7)
+	for _, h := range msg.Headers {
+		if h != nil {
+			headers = append(headers, *h)
+		}
+	}
+	add := func(k, v string) { headers = append(headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)}) }
+	add(HeaderDLQReason, reason)
+	if cause != nil {
+		add(HeaderDLQError, cause.Error())
+	}
+	var verr ValidationError
+	if errors.As(cause, &verr) {
+		if b, err := json.Marshal(verr); err == nil {
+			add(HeaderDLQFields, string(b))
+		}
+	}
+	add(HeaderDLQTopic, msg.Topic)
+	add(HeaderDLQPartition, strconv.Itoa(int(msg.Partition)))
+	add(HeaderDLQOffset, strconv.FormatInt(msg.Offset, 10))
+	add(HeaderDLQTimestamp, msg.Timestamp.UTC().Format(time.RFC3339Nano))
+
+	out := &sarama.ProducerMessage{Topic: d.topic, Headers: headers}
+	if msg.Key != nil {
+		out.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if msg.Value != nil {
+		out.Value = sarama.ByteEncoder(msg.Value)
+	}
+	if _, _, err := d.prod.SendMessage(out); err != nil {
+		return fmt.Errorf("dead-letter %s: %w", d.topic, err)
+	}
+	return nil
+}
+
+func (d *DeadLetters) Close() error {
+	if d == nil {
+		return nil
+	}
+	return d.prod.Close()
+}
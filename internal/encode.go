@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EncodedOrder — каноническое JSON-представление заказа, подготовленное один раз при записи в кэш.
+type EncodedOrder struct {
+	JSON []byte
+	Gzip []byte // nil, если gzip-вариант не строился
+	ETag string // сильный ETag по байтам JSON; у gzip-варианта — с суффиксом -gz (см. gzipETag)
+}
+
+// CachedOrder — запись кэша вместе с готовыми байтами ответа и временем помещения в кэш.
+type CachedOrder struct {
+	Order    *Order
+	Encoded  *EncodedOrder // nil, если закодировать не удалось
+	StoredAt time.Time
+	Source   string // уровень кэша, ответивший на запрос; пусто — "cache"
+}
+
+// EncodedCache — кэш, умеющий отдавать заказ сразу в закодированном виде.
+type EncodedCache interface {
+	GetEncoded(id string) (CachedOrder, bool)
+}
+
+// encodeOrder повторяет вывод json.NewEncoder(w).Encode(o), включая завершающий перевод строки.
+func encodeOrder(o *Order, withGzip bool) (*EncodedOrder, error) {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, '\n')
+	sum := sha256.Sum256(b)
+	enc := &EncodedOrder{JSON: b, ETag: `"` + hex.EncodeToString(sum[:16]) + `"`}
+	if withGzip {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+		zw.Write(b)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		enc.Gzip = buf.Bytes()
+	}
+	return enc, nil
+}
+
+// write отдаёт готовые байты: gzip-вариант, если клиент его принимает, и 304 по If-None-Match.
+// Сильный ETag различается для каждого Content-Encoding, поэтому проверяется ETag выбранного варианта.
+func (e *EncodedOrder) write(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	body, etag := e.JSON, e.ETag
+	if e.Gzip != nil && acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		h.Set("Content-Encoding", "gzip")
+		body, etag = e.Gzip, gzipETag(e.ETag)
+	}
+	h.Set("ETag", etag)
+	h.Set("Content-Type", "application/json")
+	h.Add("Vary", "Accept-Encoding")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Encoding")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Write error: %v", err)
+	}
+}
+
+// gzipETag — ETag gzip-варианта: "<хеш>-gz".
+func gzipETag(etag string) string { return strings.TrimSuffix(etag, `"`) + `-gz"` }
+
+// acceptsGzip разбирает Accept-Encoding с q-значениями: gzip принимается, если указан с q > 0
+// или не указан, но есть "*" с q > 0.
+func acceptsGzip(header string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				} else {
+					q = 0
+				}
+			}
+		}
+		switch coding = strings.ToLower(strings.TrimSpace(coding)); coding {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// etagMatch сравнивает If-None-Match со слабым сравнением (RFC 9110): префикс W/ не учитывается.
+func etagMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == etag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
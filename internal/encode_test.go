@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                         false,
+		"gzip":                     true,
+		"gzip, deflate, br":        true,
+		"deflate, GZIP;q=0.5":      true,
+		"gzip;q=0":                 false,
+		"gzip; q=0.000":            false,
+		"br, *":                    true,
+		"*;q=0":                    false,
+		"gzip;q=0, *":              false,
+		"identity, *;q=0.1":        true,
+		"deflate":                  false,
+		"x-gzip":                   true,
+		"gzip;q=bogus, identity":   false,
+		"gzip;level=1;q=1, br;q=0": true,
+	} {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestEncodedOrderETagPerEncoding(t *testing.T) {
+	enc, err := encodeOrder(testOrder("a", 1), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/order/a", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		r.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		enc.write(w, r)
+		return w
+	}
+
+	plain := serve("gzip;q=0", "")
+	zipped := serve("gzip", "")
+	if plain.Header().Get("Content-Encoding") != "" || zipped.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding: identity=%q gzip=%q", plain.Header().Get("Content-Encoding"), zipped.Header().Get("Content-Encoding"))
+	}
+	plainTag, zipTag := plain.Header().Get("ETag"), zipped.Header().Get("ETag")
+	if plainTag == zipTag {
+		t.Fatalf("identity and gzip share ETag %s", plainTag)
+	}
+
+	if w := serve("gzip", zipTag); w.Code != http.StatusNotModified {
+		t.Errorf("gzip with its own ETag: status %d, want 304", w.Code)
+	}
+	if w := serve("", plainTag); w.Code != http.StatusNotModified {
+		t.Errorf("identity with its own ETag: status %d, want 304", w.Code)
+	}
+	if w := serve("", zipTag); w.Code != http.StatusOK {
+		t.Errorf("identity with the gzip ETag: status %d, want 200", w.Code)
+	}
+	if w := serve("gzip", "W/"+zipTag); w.Code != http.StatusNotModified {
+		t.Errorf("weak comparison: status %d, want 304", w.Code)
+	}
+}
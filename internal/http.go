@@ -1,31 +1,95 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 )
 
+// lookupLimit — максимум заказов в ответе поиска по вторичному ключу.
+const lookupLimit = 100
+
+// dbLookupTimeout ограничивает общий запрос в БД: он больше не привязан к контексту одного клиента.
+const dbLookupTimeout = 5 * time.Second
+
 type HTTP struct {
-	cache *Cache
-	repo  *Repo
-	cfg   *Config
+	cache  OrderCache
+	repo   *Repo
+	cfg    atomic.Pointer[Config] // подменяется целиком в Reload; запрос читает его один раз
+	router http.Handler
+
+	// limiter ограничивает частоту запросов к API (HTTP_RATE_LIMIT); rate.Inf — без ограничения
+	limiter *rate.Limiter
+
+	access *AccessCounter // nil — чтения не учитываются
+
+	// одновременные промахи по одному order_uid склеиваются в один Repo.Get
+	flight    singleflight.Group
+	coalesced atomic.Uint64
+
+	// фоновые обновления устаревших записей (stale-while-revalidate)
+	refreshSem chan struct{}
+	refreshing sync.Map
+}
+
+type dbLookup struct {
+	o  *Order
+	ok bool
 }
 
-func NewHTTP(cache *Cache, repo *Repo, cfg *Config) http.Handler {
-	h := &HTTP{cache: cache, repo: repo, cfg: cfg}
+func NewHTTP(cache OrderCache, repo *Repo, access *AccessCounter, cfg *Config) *HTTP {
+	h := &HTTP{cache: cache, repo: repo, access: access, limiter: rate.NewLimiter(rate.Inf, 0)}
+	if cfg != nil {
+		h.refreshSem = make(chan struct{}, max(cfg.CacheRefreshConc, 1))
+		h.Reload(cfg)
+	}
 	r := httprouter.New()
 	r.GET("/order/:id", h.getOrder)
+	// track_number и customer_id не уникальны: в кэше может быть лишь часть заказов, список id — из БД
+	r.GET("/orders/track/:key", h.getOrdersBy(nil, h.repo.ListIDsByTrackNumber))
+	r.GET("/orders/customer/:key", h.getOrdersBy(nil, h.repo.ListIDsByCustomer))
+	r.GET("/orders/transaction/:key", h.getOrdersBy(OrderIndex.GetByTransaction, h.repo.ListIDsByTransaction))
 	r.ServeFiles("/static/*filepath", http.Dir("web"))
 	r.GET("/", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		http.ServeFile(w, r, "web/index.html")
 	})
-	return r
+	h.router = r
+	return h
+}
+
+// Reload применяет новые значения настроек, читаемых на каждый запрос (кэш, stale-while-revalidate, лимит частоты).
+func (h *HTTP) Reload(cfg *Config) {
+	if cfg.RateLimit > 0 {
+		h.limiter.SetLimit(rate.Limit(cfg.RateLimit))
+		h.limiter.SetBurst(max(cfg.RateBurst, 1))
+	} else {
+		h.limiter.SetLimit(rate.Inf)
+	}
+	h.cfg.Store(cfg)
+}
+
+func (h *HTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.limiter.Allow() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+	h.router.ServeHTTP(w, r)
+}
+
+// useCache — false, если кэш выключен в конфиге или запрошен ?nocache=1.
+func (h *HTTP) useCache(r *http.Request, cfg *Config) bool {
+	return cfg != nil && cfg.CacheEnabled && !r.URL.Query().Has("nocache")
 }
 
 func (h *HTTP) getOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -33,53 +97,246 @@ func (h *HTTP) getOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 	id := ps.ByName("id")
 
 	// глобально выключенный кеш или ?nocache=1
-	nocache := h.cfg == nil || !h.cfg.CacheEnabled || r.URL.Query().Has("nocache")
+	cfg := h.cfg.Load()
+	nocache := !h.useCache(r, cfg)
 
 	if !nocache { // пробуем кеш
-		if o, ok := h.cache.Get(id); ok {
-			w.Header().Set("X-Source", "cache")
+		if co, ok := h.fromCache(id); ok {
+			source := "cache"
+			if co.Source != "" {
+				source = co.Source
+			}
+			if age := time.Since(co.StoredAt); cfg.CacheSoftTTL > 0 && !co.StoredAt.IsZero() && age > cfg.CacheSoftTTL {
+				// stale-while-revalidate: отдаём сразу, обновляем в фоне
+				source = "cache-stale"
+				w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+				h.revalidate(id)
+			}
+			w.Header().Set("X-Source", source)
 			w.Header().Set("X-Duration-ms", strconv.FormatInt(time.Since(start).Milliseconds(), 10))
 			dur := time.Since(start)
 			ms := float64(dur.Nanoseconds()) / 1e6
 			w.Header().Set("X-Duration-ms", fmt.Sprintf("%.6f", ms))
-			log.Printf("[HTTP] id=%s source=cache dur_ms=%.6f", id, ms)
+			infof("[HTTP] id=%s source=%s dur_ms=%.6f", id, source, ms)
 
 			// write data
-			err := json.NewEncoder(w).Encode(o)
-			if err != nil {
-				log.Printf("Encoding error: %v", err)
-			}
+			writeOrder(w, r, co.Order, co.Encoded)
+			h.recordAccess(id)
+			return
+		}
+		if neg, ok := h.cache.(NegativeCache); ok && neg.IsMissing(id) {
+			w.Header().Set("X-Source", "cache-negative")
+			http.NotFound(w, r)
+			infof("[HTTP] id=%s source=cache-negative", id)
 			return
 		}
-		log.Printf("[HTTP] cache-miss id=%s", id)
+		debugf("[HTTP] cache-miss id=%s", id)
 	}
 
 	// идём в БД
-	o, ok, err := h.repo.Get(r.Context(), id)
+	o, ok, shared, err := h.lookup(r.Context(), id)
+	if shared {
+		n := h.coalesced.Add(1)
+		w.Header().Set("X-Coalesced", "1")
+		debugf("[HTTP] id=%s coalesced db lookup (total=%d)", id, n)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		log.Printf("DB get request (no-cache) error: %v", err)
 		return
 	}
 	if !ok {
+		if neg, isNeg := h.cache.(NegativeCache); isNeg && !nocache {
+			neg.SetMissing(id)
+		}
 		http.NotFound(w, r)
-		log.Printf("DB not found (no-cache) error: %v", err)
+		infof("[HTTP] id=%s not found", id)
 		return
 	}
 	if !nocache {
-		h.cache.Set(o)
+		h.fill(o)
 	}
 
 	w.Header().Set("X-Source", "db")
 	w.Header().Set("X-Duration-ms", strconv.FormatInt(time.Since(start).Milliseconds(), 10))
 	dur := time.Since(start)
 	ms := float64(dur.Nanoseconds()) / 1e6
-	log.Printf("[HTTP] id=%s source=db dur_ms=%.6f", id, ms)
+	infof("[HTTP] id=%s source=db dur_ms=%.6f", id, ms)
 
 	// write data
 	// fmt.Println(">> DB [", o, "]")
-	err = json.NewEncoder(w).Encode(o)
+	writeOrder(w, r, o, nil)
+	h.recordAccess(id)
+}
+
+// fill кладёт прочитанный из БД заказ в кэш (для многоуровневого кэша — по его политике read-through).
+func (h *HTTP) fill(o *Order) { fillCache(h.cache, o) }
+
+func (h *HTTP) recordAccess(id string) {
+	if h.access != nil {
+		h.access.Record(id)
+	}
+}
+
+// fromCache достаёт заказ вместе с готовыми байтами и возрастом, если бэкенд их хранит.
+func (h *HTTP) fromCache(id string) (CachedOrder, bool) {
+	if ec, ok := h.cache.(EncodedCache); ok {
+		return ec.GetEncoded(id)
+	}
+	o, ok := h.cache.Get(id)
+	return CachedOrder{Order: o}, ok
+}
+
+// revalidate в фоне перечитывает заказ из БД и обновляет кэш. Одновременно идёт не больше
+// cap(h.refreshSem) обновлений; при переполнении обновление пропускается — его запустит следующий запрос.
+func (h *HTTP) revalidate(id string) {
+	if _, busy := h.refreshing.LoadOrStore(id, struct{}{}); busy {
+		return
+	}
+	select {
+	case h.refreshSem <- struct{}{}:
+	default:
+		h.refreshing.Delete(id)
+		log.Printf("[HTTP] id=%s refresh skipped: too many in flight", id)
+		return
+	}
+	go func() {
+		defer func() {
+			<-h.refreshSem
+			h.refreshing.Delete(id)
+		}()
+		o, ok, _, err := h.lookup(context.Background(), id)
+		switch {
+		case err != nil:
+			log.Printf("[HTTP] id=%s refresh error: %v", id, err)
+		case !ok:
+			h.cache.Delete(id)
+		default:
+			h.fill(o)
+		}
+	}()
+}
+
+// ordersByIDs читает order_uid из Repo и собирает заказы: из кэша, недостающие — из БД.
+// source: "db" — все заказы из БД, "db+cache" — хотя бы один взят из кэша.
+func (h *HTTP) ordersByIDs(
+	ctx context.Context, key string,
+	listIDs func(context.Context, string, int) ([]string, error),
+	nocache bool,
+) (string, []*Order, error) {
+	ids, err := listIDs(ctx, key, lookupLimit)
+	if err != nil {
+		return "", nil, err
+	}
+	list := make([]*Order, len(ids))
+	missing := ids
+	if !nocache {
+		missing = nil
+		for i, id := range ids {
+			if o, ok := h.cache.Get(id); ok {
+				list[i] = o
+			} else {
+				missing = append(missing, id)
+			}
+		}
+	}
+	source := "db"
+	if len(missing) < len(ids) {
+		source = "db+cache"
+	}
+	fresh, err := h.repo.GetMany(ctx, missing)
+	if err != nil {
+		return "", nil, err
+	}
+	byID := make(map[string]*Order, len(fresh))
+	for _, o := range fresh {
+		byID[o.OrderUID] = o
+		if !nocache {
+			h.fill(o)
+		}
+	}
+	// порядок — как у ids; удалённые между запросами заказы пропускаются
+	out := list[:0]
+	for i, id := range ids {
+		if list[i] == nil {
+			list[i] = byID[id]
+		}
+		if list[i] != nil {
+			out = append(out, list[i])
+		}
+	}
+	return source, out, nil
+}
+
+// writeOrder пишет заказ в ответ; enc == nil — закодировать на месте.
+func writeOrder(w http.ResponseWriter, r *http.Request, o *Order, enc *EncodedOrder) {
+	if enc == nil {
+		var err error
+		if enc, err = encodeOrder(o, false); err != nil {
+			log.Printf("Encoding error: %v", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	enc.write(w, r)
+}
+
+// lookup читает заказ из БД; параллельные вызовы с одним id получают результат одного запроса,
+// включая ошибку и «не найдено». shared=true, если результат был разделён с другими запросами.
+func (h *HTTP) lookup(ctx context.Context, id string) (*Order, bool, bool, error) {
+	v, err, shared := h.flight.Do(id, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dbLookupTimeout)
+		defer cancel()
+		o, ok, err := h.repo.Get(ctx, id)
+		return dbLookup{o: o, ok: ok}, err
+	})
 	if err != nil {
-		log.Printf("Encoding error: %v", err)
+		return nil, false, shared, err
+	}
+	res := v.(dbLookup)
+	return res.o, res.ok, shared, nil
+}
+
+// getOrdersBy — поиск по вторичному ключу, не больше lookupLimit заказов, новые первыми.
+// fromCache задаётся только для ключей с ограничением UNIQUE в БД (payments.transaction): тогда
+// непустой ответ индекса кэша полон. Иначе (или при промахе индекса) список order_uid берётся из Repo,
+// а заказы — из кэша, недостающие — из БД с записью в кэш.
+func (h *HTTP) getOrdersBy(
+	fromCache func(OrderIndex, string) []*Order,
+	listIDs func(context.Context, string, int) ([]string, error),
+) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		start := time.Now()
+		key := ps.ByName("key")
+		nocache := !h.useCache(r, h.cfg.Load())
+
+		source := "cache"
+		var list []*Order
+		if idx, ok := h.cache.(OrderIndex); ok && fromCache != nil && !nocache {
+			list = fromCache(idx, key)
+		}
+		if len(list) == 0 {
+			var err error
+			source, list, err = h.ordersByIDs(r.Context(), key, listIDs, nocache)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				log.Printf("DB lookup %s=%s error: %v", r.URL.Path, key, err)
+				return
+			}
+			if len(list) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		list = list[:min(len(list), lookupLimit)]
+
+		w.Header().Set("X-Source", source)
+		ms := float64(time.Since(start).Nanoseconds()) / 1e6
+		w.Header().Set("X-Duration-ms", fmt.Sprintf("%.6f", ms))
+		infof("[HTTP] %s source=%s n=%d dur_ms=%.6f", r.URL.Path, source, len(list), ms)
+
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			log.Printf("Encoding error: %v", err)
+		}
 	}
 }
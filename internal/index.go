@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"sort"
+	"time"
+)
+
+// OrderIndex — поиск в кэше по вторичным ключам. Реализуется Cache; для бэкендов без индексов
+// HTTP-слой сразу идёт в Repo.
+type OrderIndex interface {
+	GetByTrackNumber(track string) []*Order
+	GetByCustomer(customerID string) []*Order
+	GetByTransaction(tx string) []*Order
+}
+
+// multiIndex — вторичный ключ -> множество order_uid.
+type multiIndex map[string]map[string]struct{}
+
+func (x multiIndex) add(key, id string) {
+	if key == "" {
+		return
+	}
+	ids, ok := x[key]
+	if !ok {
+		ids = make(map[string]struct{}, 1)
+		x[key] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+func (x multiIndex) remove(key, id string) {
+	ids, ok := x[key]
+	if !ok {
+		return
+	}
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(x, key)
+	}
+}
+
+type orderIndexes struct {
+	track    multiIndex
+	customer multiIndex
+	tx       multiIndex
+}
+
+func newOrderIndexes() orderIndexes {
+	return orderIndexes{track: multiIndex{}, customer: multiIndex{}, tx: multiIndex{}}
+}
+
+func (x orderIndexes) add(o *Order) {
+	x.track.add(o.TrackNumber, o.OrderUID)
+	x.customer.add(o.CustomerID, o.OrderUID)
+	x.tx.add(o.Payment.Transaction, o.OrderUID)
+}
+
+func (x orderIndexes) remove(o *Order) {
+	x.track.remove(o.TrackNumber, o.OrderUID)
+	x.customer.remove(o.CustomerID, o.OrderUID)
+	x.tx.remove(o.Payment.Transaction, o.OrderUID)
+}
+
+func (c *Cache) GetByTrackNumber(track string) []*Order {
+	return c.lookup(func(x orderIndexes) multiIndex { return x.track }, track)
+}
+
+func (c *Cache) GetByCustomer(customerID string) []*Order {
+	return c.lookup(func(x orderIndexes) multiIndex { return x.customer }, customerID)
+}
+
+func (c *Cache) GetByTransaction(tx string) []*Order {
+	return c.lookup(func(x orderIndexes) multiIndex { return x.tx }, tx)
+}
+
+// lookup обходит индексы всех сегментов и возвращает живые записи по вторичному ключу
+// (новые по date_created — первыми); как и Get, считает их использованными.
+func (c *Cache) lookup(index func(orderIndexes) multiIndex, key string) []*Order {
+	now := time.Now()
+	var out []*Order
+	for _, s := range c.shardList() {
+		s.mu.Lock()
+		for id := range index(s.idx)[key] {
+			el := s.m[id]
+			if e := el.Value.(*cacheEntry); e.expired(now) {
+				continue // удалит Get или janitor
+			}
+			s.ll.MoveToFront(el)
+			out = append(out, el.Value.(*cacheEntry).o)
+		}
+		s.mu.Unlock()
+	}
+	for i, o := range out {
+		out[i] = o.Clone() // записи неизменяемы, копируем уже без блокировки
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DateCreated.After(out[j].DateCreated) })
+	return out
+}
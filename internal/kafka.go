@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// Механизмы SASL (KAFKA_SASL_MECHANISM).
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// KafkaSecurity — параметры защищённого подключения к Kafka, общие для consumer'а и producer'а.
+type KafkaSecurity struct {
+	TLS        bool
+	CAFile     string // пусто — системные корневые сертификаты
+	CertFile   string // клиентский сертификат (mTLS), задаётся вместе с KeyFile
+	KeyFile    string
+	ServerName string // пусто — имя хоста брокера
+
+	SASLMechanism string // пусто — без SASL
+	SASLUser      string
+	SASLPassword  string
+}
+
+// check возвращает ошибки несовместимых настроек и нечитаемых файлов.
+func (s KafkaSecurity) check() []error {
+	var errs []error
+	if !s.TLS && (s.CAFile != "" || s.CertFile != "" || s.KeyFile != "" || s.ServerName != "") {
+		errs = append(errs, errors.New("KAFKA_TLS_*: TLS settings are set but KAFKA_TLS is off"))
+	}
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		errs = append(errs, errors.New("KAFKA_TLS_CERT_FILE and KAFKA_TLS_KEY_FILE must be set together"))
+	}
+	if s.TLS {
+		if _, err := s.tlsConfig(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	switch s.SASLMechanism {
+	case "":
+		if s.SASLUser != "" || s.SASLPassword != "" {
+			errs = append(errs, errors.New("KAFKA_SASL_USERNAME/PASSWORD are set but KAFKA_SASL_MECHANISM is empty"))
+		}
+	case SASLPlain, SASLScramSHA256, SASLScramSHA512:
+		if s.SASLUser == "" || s.SASLPassword == "" {
+			errs = append(errs, fmt.Errorf("KAFKA_SASL_MECHANISM=%s requires KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD", s.SASLMechanism))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("KAFKA_SASL_MECHANISM: %q is not one of %s, %s, %s",
+			s.SASLMechanism, SASLPlain, SASLScramSHA256, SASLScramSHA512))
+	}
+	return errs
+}
+
+func (s KafkaSecurity) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{ServerName: s.ServerName, MinVersion: tls.VersionTLS12}
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("KAFKA_TLS_CA_FILE: %w", err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("KAFKA_TLS_CA_FILE: no PEM certificates in %s", s.CAFile)
+		}
+	}
+	if s.CertFile != "" && s.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("KAFKA_TLS_CERT_FILE/KAFKA_TLS_KEY_FILE: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// Apply включает в конфиге sarama TLS и SASL согласно настройкам.
+func (s KafkaSecurity) Apply(cfg *sarama.Config) error {
+	if errs := s.check(); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if s.TLS {
+		tc, err := s.tlsConfig()
+		if err != nil {
+			return err
+		}
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tc
+	}
+	if s.SASLMechanism == "" {
+		return nil
+	}
+	cfg.Net.SASL.Enable = true
+	cfg.Net.SASL.Handshake = true
+	cfg.Net.SASL.User = s.SASLUser
+	cfg.Net.SASL.Password = s.SASLPassword
+	switch s.SASLMechanism {
+	case SASLPlain:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SASLScramSHA256:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA256} }
+	case SASLScramSHA512:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA512} }
+	}
+	return nil
+}
+
+// scramClient — sarama.SCRAMClient поверх xdg-go/scram.
+type scramClient struct {
+	hash scram.HashGeneratorFcn
+	conv *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(user, password, authzID string) error {
+	client, err := c.hash.NewClient(user, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conv = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) { return c.conv.Step(challenge) }
+
+func (c *scramClient) Done() bool { return c.conv.Done() }
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	listenMinBackoff = time.Second
+	listenMaxBackoff = 30 * time.Second
+)
+
+// Invalidator слушает OrdersChannel на отдельном соединении и поддерживает кэш в памяти процесса
+// (Cache или L1 двухуровневого кэша) в согласии с записями других реплик. Общий уровень (Redis)
+// не трогается: его обновляет реплика, записавшая заказ. После любого разрыва локальный кэш
+// полностью пересинхронизируется, так как уведомления, пришедшие без подписки, потеряны.
+type Invalidator struct {
+	pgURL  string
+	cache  *Cache
+	repo   *Repo
+	warmer *Warmer
+}
+
+// NewInvalidator: warmer должен прогревать тот же local, а не общий уровень.
+func NewInvalidator(pgURL string, local *Cache, repo *Repo, warmer *Warmer) *Invalidator {
+	return &Invalidator{pgURL: pgURL, cache: local, repo: repo, warmer: warmer}
+}
+
+// Run переподключается с экспоненциальной задержкой, пока не отменён ctx.
+func (l *Invalidator) Run(ctx context.Context) {
+	backoff := listenMinBackoff
+	resync := false // первое подключение идёт сразу после прогрева в main
+	for {
+		err := l.listen(ctx, resync, func() { backoff = listenMinBackoff })
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[LISTEN] %s: %v, reconnect in %s", OrdersChannel, err, backoff)
+		resync = true
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, listenMaxBackoff)
+	}
+}
+
+func (l *Invalidator) listen(ctx context.Context, resync bool, connected func()) error {
+	conn, err := pgx.Connect(ctx, l.pgURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{OrdersChannel}.Sanitize()); err != nil {
+		return err
+	}
+	connected()
+	log.Printf("[LISTEN] subscribed to %s", OrdersChannel)
+	if resync {
+		l.resync(ctx)
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var ch OrderChange
+		if err := json.Unmarshal([]byte(n.Payload), &ch); err != nil || ch.OrderUID == "" {
+			log.Printf("[LISTEN] bad payload %q: %v", n.Payload, err)
+			continue
+		}
+		l.apply(ctx, ch)
+	}
+}
+
+// apply удаляет или обновляет затронутую запись. Заказы, которых нет в кэше, не подтягиваются:
+// они попадут туда при первом чтении.
+func (l *Invalidator) apply(ctx context.Context, ch OrderChange) {
+	if ch.Op == OrderDeleted {
+		l.cache.Delete(ch.OrderUID)
+		return
+	}
+	cur, ok := l.cache.Peek(ch.OrderUID) // служебное чтение: не искажает статистику и LRU
+	if !ok {
+		l.cache.Delete(ch.OrderUID) // сбрасывает отметку «заказа нет», если она была
+		return
+	}
+	if !cur.UpdatedAt.Before(ch.UpdatedAt) {
+		return // уже не старше (например, запись сделала эта же реплика)
+	}
+	o, found, err := l.repo.Get(ctx, ch.OrderUID)
+	switch {
+	case err != nil:
+		log.Printf("[LISTEN] refresh id=%s: %v, dropping entry", ch.OrderUID, err)
+		l.cache.Delete(ch.OrderUID)
+	case !found:
+		l.cache.Delete(ch.OrderUID)
+	default:
+		l.cache.Set(o)
+	}
+}
+
+func (l *Invalidator) resync(ctx context.Context) {
+	l.cache.DeleteAllItems()
+	n, err := l.warmer.Run(ctx)
+	if err != nil {
+		log.Printf("[LISTEN] resync: %v", err)
+		return
+	}
+	log.Printf("[LISTEN] resync: %d orders reloaded", n)
+}
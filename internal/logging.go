@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// Уровни логирования (LOG_LEVEL). Ошибки пишутся всегда через log.Printf;
+// по уровню фильтруются только частые записи о запросах и сообщениях.
+const (
+	LogDebug = "debug" // плюс промахи кэша и склеенные запросы
+	LogInfo  = "info"  // каждый запрос и каждое сообщение Kafka
+	LogError = "error" // только ошибки
+)
+
+var logLevel atomic.Int32 // значение из logLevels; 0 — info
+
+var logLevels = map[string]int32{LogInfo: 0, LogDebug: -1, LogError: 1}
+
+// SetLogLevel меняет уровень логирования; неизвестный уровень игнорируется.
+func SetLogLevel(level string) {
+	if v, ok := logLevels[level]; ok {
+		logLevel.Store(v)
+	}
+}
+
+func debugf(format string, args ...any) {
+	if logLevel.Load() <= logLevels[LogDebug] {
+		log.Printf(format, args...)
+	}
+}
+
+func infof(format string, args ...any) {
+	if logLevel.Load() <= logLevels[LogInfo] {
+		log.Printf(format, args...)
+	}
+}
@@ -54,4 +54,31 @@ type Order struct {
 	SmID              int       `json:"sm_id"`
 	DateCreated       time.Time `json:"date_created"`
 	OofShard          string    `json:"oof_shard"`
+
+	// Version — монотонная версия заказа: поле version сообщения, а без него — время сообщения Kafka
+	// в микросекундах. VersionSeq различает версии с одинаковым Version: offset сообщения
+	// для версии по времени, 0 — для явной. Более старая версия не перезаписывает сохранённую (см. olderThan).
+	Version    int64 `json:"version,omitempty"`
+	VersionSeq int64 `json:"-"`
+
+	// UpdatedAt — время последней записи в БД (orders.updated_at), в JSON не отдаётся.
+	UpdatedAt time.Time `json:"-"`
+}
+
+// olderThan сообщает, что версия o строго меньше версии p: сначала по Version, затем по VersionSeq.
+func (o *Order) olderThan(p *Order) bool {
+	if o.Version != p.Version {
+		return o.Version < p.Version
+	}
+	return o.VersionSeq < p.VersionSeq
+}
+
+// Clone возвращает глубокую копию заказа: у копии свой срез Items.
+func (o *Order) Clone() *Order {
+	c := *o
+	if o.Items != nil {
+		c.Items = make([]Item, len(o.Items))
+		copy(c.Items, o.Items)
+	}
+	return &c
 }
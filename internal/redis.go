@@ -0,0 +1,281 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// redisFillScript записывает заказ, только если сохранённая версия не новее: (version, version_seq)
+// сравниваются так же, как в Order.olderThan. KEYS[1] — ключ, ARGV: JSON, version, version_seq, PX в мс или "".
+// Версии по времени (~1.7e15 мкс) помещаются в double Lua без потери точности.
+const redisFillScript = `
+local cur = redis.call('GET', KEYS[1])
+if cur then
+  local o = cjson.decode(cur)
+  local v, s = tonumber(o.version) or 0, tonumber(o.version_seq) or 0
+  local nv, ns = tonumber(ARGV[2]), tonumber(ARGV[3])
+  if nv < v or (nv == v and ns < s) then
+    return 0
+  end
+end
+if ARGV[4] ~= '' then
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[4])
+else
+  redis.call('SET', KEYS[1], ARGV[1])
+end
+return 1
+`
+
+const (
+	redisIdleConns = 8
+	redisTimeout   = time.Second
+	redisScanCount = "500"
+)
+
+type RedisOptions struct {
+	Addr     string
+	Password string
+	Prefix   string        // префикс ключей, по умолчанию "order:"
+	TTL      time.Duration // 0 — без срока жизни
+}
+
+// redisOrder — формат значения в Redis: JSON заказа плюс updated_at и version_seq, которые скрыты
+// из JSON Order, но нужны для сравнения версий (инвалидация, сверка).
+type redisOrder struct {
+	*Order
+	UpdatedAt  time.Time `json:"updated_at"`
+	VersionSeq int64     `json:"version_seq,omitempty"`
+}
+
+// RedisCache — OrderCache поверх Redis-совместимого хранилища: заказы лежат в JSON под ключом prefix+order_uid.
+type RedisCache struct {
+	opts  RedisOptions
+	ttl   atomic.Int64 // opts.TTL, меняется на ходу через SetTTL
+	conns chan *respConn
+}
+
+// NewRedisCache проверяет доступность сервера (PING) и возвращает кэш.
+func NewRedisCache(opts RedisOptions) (*RedisCache, error) {
+	if opts.Addr == "" {
+		return nil, fmt.Errorf("redis: empty address")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "order:"
+	}
+	c := &RedisCache{opts: opts, conns: make(chan *respConn, redisIdleConns)}
+	c.SetTTL(opts.TTL)
+	if _, err := c.do([]string{"PING"}); err != nil {
+		return nil, fmt.Errorf("redis ping %s: %w", opts.Addr, err)
+	}
+	return c, nil
+}
+
+func (c *RedisCache) Get(id string) (*Order, bool) {
+	res, err := c.do([]string{"GET", c.key(id)})
+	if err != nil {
+		log.Printf("[REDIS] get id=%s: %v", id, err)
+		return nil, false
+	}
+	b, ok := res[0].([]byte)
+	if !ok {
+		return nil, false
+	}
+	v := redisOrder{Order: &Order{}}
+	if err := json.Unmarshal(b, &v); err != nil {
+		log.Printf("[REDIS] decode id=%s: %v", id, err)
+		return nil, false
+	}
+	v.Order.UpdatedAt = v.UpdatedAt
+	v.Order.VersionSeq = v.VersionSeq
+	return v.Order, true
+}
+
+// Set безусловно записывает заказ — для изменений от consumer'а, уже прошедших проверку версии в БД.
+func (c *RedisCache) Set(o *Order) {
+	cmd, err := c.setCmd(o)
+	if err == nil {
+		_, err = c.do(cmd)
+	}
+	if err != nil {
+		log.Printf("[REDIS] set id=%s: %v", o.OrderUID, err)
+	}
+}
+
+// Fill записывает прочитанный из БД заказ, если в Redis нет более новой версии: чтение, сделанное
+// до commit'а consumer'а, не должно затереть его запись для всех реплик.
+func (c *RedisCache) Fill(o *Order) {
+	cmd, err := c.fillCmd(o)
+	if err == nil {
+		_, err = c.do(cmd)
+	}
+	if err != nil {
+		log.Printf("[REDIS] fill id=%s: %v", o.OrderUID, err)
+	}
+}
+
+// Warm, как и Fill, не затирает более новые версии: прогрев читает заказы из БД.
+func (c *RedisCache) Warm(list []*Order) {
+	cmds := make([][]string, 0, len(list))
+	for _, o := range list {
+		cmd, err := c.fillCmd(o)
+		if err != nil {
+			log.Printf("[REDIS] warm id=%s: %v", o.OrderUID, err)
+			continue
+		}
+		cmds = append(cmds, cmd)
+	}
+	if len(cmds) == 0 {
+		return
+	}
+	if _, err := c.do(cmds...); err != nil {
+		log.Printf("[REDIS] warm: %v", err)
+	}
+}
+
+func (c *RedisCache) Delete(orderUID string) {
+	if _, err := c.do([]string{"DEL", c.key(orderUID)}); err != nil {
+		log.Printf("[REDIS] delete id=%s: %v", orderUID, err)
+	}
+}
+
+// DeleteAllItems удаляет только ключи с нашим префиксом (SCAN + DEL), не трогая остальную базу.
+func (c *RedisCache) DeleteAllItems() {
+	cursor := "0"
+	for {
+		res, err := c.do([]string{"SCAN", cursor, "MATCH", c.opts.Prefix + "*", "COUNT", redisScanCount})
+		if err != nil {
+			log.Printf("[REDIS] flush: %v", err)
+			return
+		}
+		page, ok := res[0].([]any)
+		if !ok || len(page) != 2 {
+			log.Printf("[REDIS] flush: unexpected SCAN reply %v", res[0])
+			return
+		}
+		next, _ := page[0].([]byte)
+		keys, _ := page[1].([]any)
+		if len(keys) > 0 {
+			del := []string{"DEL"}
+			for _, k := range keys {
+				if b, ok := k.([]byte); ok {
+					del = append(del, string(b))
+				}
+			}
+			if _, err := c.do(del); err != nil {
+				log.Printf("[REDIS] flush: %v", err)
+				return
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+// SetTTL меняет срок жизни для последующих записей; уже сохранённые ключи живут со своим сроком.
+func (c *RedisCache) SetTTL(ttl time.Duration) { c.ttl.Store(int64(ttl)) }
+
+func (c *RedisCache) key(id string) string { return c.opts.Prefix + id }
+
+func (c *RedisCache) setCmd(o *Order) ([]string, error) {
+	b, err := json.Marshal(redisOrder{Order: o, UpdatedAt: o.UpdatedAt, VersionSeq: o.VersionSeq})
+	if err != nil {
+		return nil, err
+	}
+	cmd := []string{"SET", c.key(o.OrderUID), string(b)}
+	if px := c.px(); px != "" {
+		cmd = append(cmd, "PX", px)
+	}
+	return cmd, nil
+}
+
+func (c *RedisCache) fillCmd(o *Order) ([]string, error) {
+	b, err := json.Marshal(redisOrder{Order: o, UpdatedAt: o.UpdatedAt, VersionSeq: o.VersionSeq})
+	if err != nil {
+		return nil, err
+	}
+	return []string{"EVAL", redisFillScript, "1", c.key(o.OrderUID), string(b),
+		strconv.FormatInt(o.Version, 10), strconv.FormatInt(o.VersionSeq, 10), c.px()}, nil
+}
+
+// px — срок жизни в миллисекундах для SET PX; пусто — без срока.
+func (c *RedisCache) px() string {
+	if ttl := time.Duration(c.ttl.Load()); ttl > 0 {
+		return strconv.FormatInt(ttl.Milliseconds(), 10)
+	}
+	return ""
+}
+
+// do выполняет команды на свободном соединении из пула. Первая ошибка сервера в ответах возвращается как err.
+// Соединение из пула могло быть закрыто сервером или прокси, пока простаивало: при ошибке на нём
+// команды один раз повторяются на новом соединении (GET, SET, EVAL заполнения, DEL и SCAN идемпотентны).
+func (c *RedisCache) do(cmds ...[]string) ([]any, error) {
+	rc, pooled, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+	res, err := rc.send(redisTimeout, cmds...)
+	if err != nil && pooled {
+		_ = rc.Close()
+		if rc, err = c.dial(); err != nil {
+			return nil, err
+		}
+		res, err = rc.send(redisTimeout, cmds...)
+	}
+	if err != nil {
+		// состояние соединения неизвестно — не возвращаем его в пул
+		_ = rc.Close()
+		return nil, err
+	}
+	c.release(rc)
+	for _, v := range res {
+		if e, ok := v.(respError); ok {
+			return res, e
+		}
+	}
+	return res, nil
+}
+
+// conn возвращает соединение из пула (pooled = true) или новое.
+func (c *RedisCache) conn() (*respConn, bool, error) {
+	select {
+	case rc := <-c.conns:
+		return rc, true, nil
+	default:
+	}
+	rc, err := c.dial()
+	return rc, false, err
+}
+
+func (c *RedisCache) dial() (*respConn, error) {
+	rc, err := dialRESP(c.opts.Addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.Password != "" {
+		res, err := rc.send(redisTimeout, []string{"AUTH", c.opts.Password})
+		if err == nil {
+			if e, ok := res[0].(respError); ok {
+				err = e
+			}
+		}
+		if err != nil {
+			_ = rc.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	return rc, nil
+}
+
+func (c *RedisCache) release(rc *respConn) {
+	select {
+	case c.conns <- rc:
+	default:
+		_ = rc.Close()
+	}
+}
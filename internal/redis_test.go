@@ -0,0 +1,351 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis — RESP-сервер в процессе теста: GET, SET [PX ms], DEL, SCAN cursor MATCH prefix* COUNT n, PING, AUTH
+// и EVAL redisFillScript, выполняемый на Go (Lua-интерпретатора здесь нет).
+// SCAN отдаёт ключи страницами по scanPage, чтобы проверить обход курсором; как и в Redis,
+// ключи, удалённые между страницами, не сдвигают обход.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu       sync.Mutex
+	data     map[string]string
+	px       map[string]string // аргумент PX последнего SET; пусто — без срока
+	cursors  []string          // курсор SCAN n>0 — продолжить после ключа cursors[n-1]
+	conns    []net.Conn
+	accepted int
+}
+
+const scanPage = 2
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, password: password, data: map[string]string{}, px: map[string]string{}}
+	go f.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		f.dropConns()
+	})
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeRedis) serve() {
+	for {
+		c, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns = append(f.conns, c)
+		f.accepted++
+		f.mu.Unlock()
+		go f.handle(c)
+	}
+}
+
+// dropConns рвёт все открытые соединения, как при перезапуске сервера.
+func (f *fakeRedis) dropConns() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.conns {
+		c.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeRedis) handle(c net.Conn) {
+	defer c.Close()
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	authed := f.password == ""
+	for {
+		v, err := readReply(r)
+		if err != nil {
+			return
+		}
+		raw, _ := v.([]any)
+		args := make([]string, len(raw))
+		for i, a := range raw {
+			b, _ := a.([]byte)
+			args[i] = string(b)
+		}
+		if len(args) == 0 {
+			fmt.Fprint(w, "-ERR empty command\r\n")
+		} else if cmd := strings.ToUpper(args[0]); cmd == "AUTH" {
+			if len(args) == 2 && args[1] == f.password {
+				authed = true
+				fmt.Fprint(w, "+OK\r\n")
+			} else {
+				fmt.Fprint(w, "-WRONGPASS invalid password\r\n")
+			}
+		} else if !authed {
+			fmt.Fprint(w, "-NOAUTH Authentication required.\r\n")
+		} else {
+			f.exec(w, cmd, args[1:])
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) exec(w *bufio.Writer, cmd string, args []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case cmd == "PING":
+		fmt.Fprint(w, "+PONG\r\n")
+	case cmd == "GET" && len(args) == 1:
+		v, ok := f.data[args[0]]
+		if !ok {
+			fmt.Fprint(w, "$-1\r\n")
+			return
+		}
+		writeBulk(w, v)
+	case cmd == "SET" && (len(args) == 2 || len(args) == 4 && strings.ToUpper(args[2]) == "PX"):
+		f.data[args[0]] = args[1]
+		f.px[args[0]] = ""
+		if len(args) == 4 {
+			f.px[args[0]] = args[3]
+		}
+		fmt.Fprint(w, "+OK\r\n")
+	case cmd == "EVAL" && len(args) == 7 && args[0] == redisFillScript && args[1] == "1":
+		key, val, px := args[2], args[3], args[6]
+		if cur, ok := f.data[key]; ok {
+			var o struct {
+				Version    int64 `json:"version"`
+				VersionSeq int64 `json:"version_seq"`
+			}
+			if err := json.Unmarshal([]byte(cur), &o); err != nil {
+				fmt.Fprintf(w, "-ERR cjson: %v\r\n", err)
+				return
+			}
+			v, _ := strconv.ParseInt(args[4], 10, 64)
+			seq, _ := strconv.ParseInt(args[5], 10, 64)
+			if v < o.Version || v == o.Version && seq < o.VersionSeq {
+				fmt.Fprint(w, ":0\r\n")
+				return
+			}
+		}
+		f.data[key], f.px[key] = val, px
+		fmt.Fprint(w, ":1\r\n")
+	case cmd == "DEL" && len(args) > 0:
+		n := 0
+		for _, k := range args {
+			if _, ok := f.data[k]; ok {
+				delete(f.data, k)
+				n++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", n)
+	case cmd == "SCAN" && len(args) == 5 && strings.HasSuffix(args[2], "*"):
+		after := ""
+		if n, _ := strconv.Atoi(args[0]); n > 0 && n <= len(f.cursors) {
+			after = f.cursors[n-1]
+		}
+		prefix := strings.TrimSuffix(args[2], "*")
+		var keys []string
+		for k := range f.data {
+			if strings.HasPrefix(k, prefix) && k > after {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		page, next := keys, "0"
+		if len(keys) > scanPage {
+			page = keys[:scanPage]
+			f.cursors = append(f.cursors, page[len(page)-1])
+			next = strconv.Itoa(len(f.cursors))
+		}
+		fmt.Fprint(w, "*2\r\n")
+		writeBulk(w, next)
+		fmt.Fprintf(w, "*%d\r\n", len(page))
+		for _, k := range page {
+			writeBulk(w, k)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unsupported %s %v\r\n", cmd, args)
+	}
+}
+
+func writeBulk(w *bufio.Writer, s string) { fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s) }
+
+func newTestRedisCache(t *testing.T, f *fakeRedis, ttl time.Duration) *RedisCache {
+	t.Helper()
+	c, err := NewRedisCache(RedisOptions{Addr: f.addr(), Password: f.password, TTL: ttl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRedisCacheRoundTrip(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	c := newTestRedisCache(t, f, 0)
+
+	o := testOrder("a", 1)
+	o.UpdatedAt = time.Date(2025, 3, 1, 12, 30, 45, 123456000, time.UTC)
+	c.Set(o)
+
+	got, ok := c.Get("a")
+	if !ok {
+		t.Fatal("order not found after Set")
+	}
+	if !got.UpdatedAt.Equal(o.UpdatedAt) {
+		t.Errorf("UpdatedAt = %v, want %v", got.UpdatedAt, o.UpdatedAt)
+	}
+	if !reflect.DeepEqual(got, o) {
+		t.Errorf("got %+v, want %+v", got, o)
+	}
+	if _, ok := c.Get("missing"); ok {
+		t.Error("Get of a missing key reported a hit")
+	}
+
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Error("order still cached after Delete")
+	}
+}
+
+func TestRedisCacheAuthRequired(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	if _, err := NewRedisCache(RedisOptions{Addr: f.addr(), Password: "wrong"}); err == nil {
+		t.Fatal("NewRedisCache succeeded with a wrong password")
+	}
+}
+
+func TestRedisCacheExpiry(t *testing.T) {
+	f := newFakeRedis(t, "")
+	c := newTestRedisCache(t, f, 1500*time.Millisecond)
+
+	c.Set(testOrder("a", 1))
+	c.Warm([]*Order{testOrder("b", 1)})
+	c.SetTTL(0)
+	c.Set(testOrder("c", 1))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, want := range map[string]string{"order:a": "1500", "order:b": "1500", "order:c": ""} {
+		if got := f.px[key]; got != want {
+			t.Errorf("%s: PX = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestRedisCacheDeleteAllItemsKeepsForeignKeys(t *testing.T) {
+	f := newFakeRedis(t, "")
+	c := newTestRedisCache(t, f, 0)
+
+	var orders []*Order
+	for i := 0; i < 5; i++ {
+		orders = append(orders, testOrder(fmt.Sprintf("id-%d", i), 1))
+	}
+	c.Warm(orders)
+	f.mu.Lock()
+	f.data["session:1"] = "x"
+	f.data["orders-archive"] = "y"
+	f.mu.Unlock()
+
+	c.DeleteAllItems()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var left []string
+	for k := range f.data {
+		left = append(left, k)
+	}
+	sort.Strings(left)
+	if want := []string{"orders-archive", "session:1"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("keys after DeleteAllItems = %v, want %v", left, want)
+	}
+}
+
+// Соединение из пула, закрытое сервером, пока простаивало: команда повторяется на новом соединении.
+func TestRedisCacheRedialsBrokenConnection(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	c := newTestRedisCache(t, f, 0)
+	c.Set(testOrder("a", 1))
+	if n := len(c.conns); n != 1 {
+		t.Fatalf("idle connections = %d, want 1", n)
+	}
+
+	f.dropConns()
+	c.Set(testOrder("a", 2))
+	if o, ok := c.Get("a"); !ok || o.Version != 2 {
+		t.Fatalf("write after the server closed the idle connection was lost: %+v", o)
+	}
+	if n := len(c.conns); n != 1 {
+		t.Fatalf("idle connections = %d, want 1", n)
+	}
+	f.mu.Lock()
+	if f.accepted != 2 {
+		t.Errorf("accepted connections = %d, want 2", f.accepted)
+	}
+	f.mu.Unlock()
+
+	// новое соединение не повторяется: недоступный сервер — ошибка
+	f.ln.Close()
+	f.dropConns()
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("Get succeeded with the server down")
+	}
+	if n := len(c.conns); n != 0 {
+		t.Fatalf("broken connection returned to the pool: idle = %d", n)
+	}
+}
+
+// Redis-вариант TestCacheKeepsNewerVersion: заполнение после чтения из БД не затирает запись consumer'а.
+func TestRedisCacheFillKeepsNewerVersion(t *testing.T) {
+	f := newFakeRedis(t, "")
+	c := newTestRedisCache(t, f, time.Minute)
+
+	c.Set(testOrder("a", 2))
+	c.Fill(testOrder("a", 1))
+	c.Warm([]*Order{testOrder("a", 1)})
+	if o, _ := c.Get("a"); o == nil || o.Version != 2 {
+		t.Fatalf("got %+v, want version 2", o)
+	}
+
+	same := testOrder("a", 2)
+	same.Entry = "refreshed"
+	c.Fill(same)
+	if o, _ := c.Get("a"); o == nil || o.Entry != "refreshed" {
+		t.Errorf("equal version was not replaced: %+v", o)
+	}
+
+	seq := testOrder("a", 2)
+	seq.VersionSeq = 5
+	c.Fill(seq)
+	older := testOrder("a", 2)
+	older.VersionSeq = 4
+	c.Fill(older)
+	if o, _ := c.Get("a"); o == nil || o.VersionSeq != 5 {
+		t.Errorf("got %+v, want version_seq 5", o)
+	}
+
+	c.Fill(testOrder("b", 1))
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.px["order:b"] != "60000" {
+		t.Errorf("fill PX = %q, want 60000", f.px["order:b"])
+	}
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// reloadable — настройки, которые применяются по SIGHUP без перезапуска. Остальные
+// (адреса, Kafka, бэкенд кэша, число сегментов, фоновые задачи) требуют перезапуска.
+var reloadable = []string{
+	"CACHE_ENABLED", "CACHE_TTL", "CACHE_SOFT_TTL", "CACHE_MAX_ENTRIES", "CACHE_MAX_BYTES",
+	"CACHE_NEGATIVE_TTL", "CACHE_NEGATIVE_MAX", "CACHE_GZIP",
+	"CACHE_L1_MAX_ENTRIES", "CACHE_L1_MAX_BYTES",
+	"CACHE_L1_READ_THROUGH", "CACHE_L1_WRITE_THROUGH", "CACHE_L2_READ_THROUGH", "CACHE_L2_WRITE_THROUGH",
+	"LOG_LEVEL", "HTTP_RATE_LIMIT", "HTTP_RATE_BURST",
+}
+
+// setRuntime копирует в c значения настроек из списка reloadable.
+func (c *Config) setRuntime(n Config) {
+	c.CacheEnabled = n.CacheEnabled
+	c.CacheTTL = n.CacheTTL
+	c.CacheSoftTTL = n.CacheSoftTTL
+	c.CacheMaxEntries = n.CacheMaxEntries
+	c.CacheMaxBytes = n.CacheMaxBytes
+	c.CacheNegTTL = n.CacheNegTTL
+	c.CacheNegMax = n.CacheNegMax
+	c.CacheGzip = n.CacheGzip
+	c.CacheL1MaxEntries = n.CacheL1MaxEntries
+	c.CacheL1MaxBytes = n.CacheL1MaxBytes
+	c.L1ReadThrough = n.L1ReadThrough
+	c.L1WriteThrough = n.L1WriteThrough
+	c.L2ReadThrough = n.L2ReadThrough
+	c.L2WriteThrough = n.L2WriteThrough
+	c.LogLevel = n.LogLevel
+	c.RateLimit = n.RateLimit
+	c.RateBurst = n.RateBurst
+}
+
+// Reloader перечитывает конфигурацию и применяет безопасные настройки к кэшу, HTTP и логированию.
+// Переменные окружения процесса не меняются, поэтому на ходу имеет смысл менять файл конфигурации.
+type Reloader struct {
+	load  func() (Config, error)
+	cache OrderCache
+	http  *HTTP
+
+	mu  sync.Mutex
+	cur Config // действующая конфигурация: стартовая плюс применённые изменения
+}
+
+func NewReloader(cfg Config, load func() (Config, error), cache OrderCache, h *HTTP) *Reloader {
+	return &Reloader{load: load, cache: cache, http: h, cur: cfg}
+}
+
+// Run перезагружает настройки по каждому SIGHUP, пока не отменён ctx.
+func (r *Reloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if _, err := r.Reload(); err != nil {
+				log.Printf("[RELOAD] rejected: %v", err)
+			}
+		}
+	}
+}
+
+// Reload перечитывает конфигурацию. Невалидная конфигурация отклоняется целиком. Изменённые безопасные
+// настройки применяются вместе, остальные изменения возвращаются списком: для них нужен перезапуск.
+func (r *Reloader) Reload() (restart []string, err error) {
+	next, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var applied []string
+	for k := range maps.Keys(next.shown) {
+		if next.shown[k] == r.cur.shown[k] {
+			continue
+		}
+		if slices.Contains(reloadable, k) {
+			applied = append(applied, k)
+		} else {
+			restart = append(restart, k)
+		}
+	}
+	slices.Sort(applied)
+	slices.Sort(restart)
+	if len(restart) > 0 {
+		log.Printf("[RELOAD] changed but need a restart: %s", strings.Join(restart, ", "))
+	}
+	if len(applied) == 0 {
+		log.Printf("[RELOAD] no runtime settings changed")
+		return restart, nil
+	}
+
+	cfg := r.cur
+	cfg.setRuntime(next)
+	cfg.shown = maps.Clone(r.cur.shown)
+	for _, k := range applied {
+		cfg.shown[k] = next.shown[k]
+	}
+	ReconfigureCache(r.cache, cfg)
+	SetLogLevel(cfg.LogLevel)
+	if r.http != nil {
+		r.http.Reload(&cfg)
+	}
+	r.cur = cfg
+	log.Printf("[RELOAD] applied: %s", describe(applied, cfg.shown))
+	return restart, nil
+}
+
+func describe(keys []string, shown map[string]any) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%v", k, shown[k])
+	}
+	return strings.Join(parts, ", ")
+}
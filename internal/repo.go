@@ -2,41 +2,102 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"log"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// OrdersChannel — канал LISTEN/NOTIFY, в который Repo сообщает об изменении заказов.
+const OrdersChannel = "order_changes"
+
+const (
+	OrderUpserted = "upsert"
+	OrderDeleted  = "delete"
+)
+
+// OrderChange — payload уведомления в OrdersChannel.
+type OrderChange struct {
+	Op        string    `json:"op"`
+	OrderUID  string    `json:"order_uid"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 type Repo struct{ Pool *pgxpool.Pool }
 
 func NewRepo(pool *pgxpool.Pool) *Repo { return &Repo{Pool: pool} }
 
-// Upsert — одна транзакция:
+// Upsert записывает один заказ (см. UpsertBatch); false — версия не новее сохранённой, заказ пропущен.
+func (r *Repo) Upsert(ctx context.Context, o *Order) (bool, error) {
+	applied, err := r.UpsertBatch(ctx, []*Order{o})
+	if err != nil {
+		return false, err
+	}
+	return applied[0], nil
+}
+
+// UpsertBatch — одна транзакция на все заказы, запросы уходят пачками (pgx.Batch), а не по одному:
 // 1) upsert шапки заказа
 // 2) upsert delivery
 // 3) upsert payment
-// 4) replace items (delete + batch insert)
-func (r *Repo) Upsert(ctx context.Context, o *Order) error {
+// 4) replace items (delete + insert)
+// 5) pg_notify в OrdersChannel — уходит подписчикам только после commit
+// Шапка пишется, только если (Version, VersionSeq) новее сохранённой; для устаревших заказов шаги 2–5 пропускаются,
+// applied[i] = false. Заказы пишутся по порядку: при повторе order_uid в пачке побеждает большая версия.
+// UpdatedAt применённых заказов заполняется значением из БД.
+func (r *Repo) UpsertBatch(ctx context.Context, orders []*Order) (applied []bool, err error) {
+	applied = make([]bool, len(orders))
+	if len(orders) == 0 {
+		return applied, nil
+	}
 	tx, err := r.Pool.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		log.Println("Transaction error (Upsert)")
-		return err
+		return nil, err
 	}
-	defer func() {
-		// rollback по умолчанию, commit явно ниже
-		if tx != nil {
-			_ = tx.Rollback(ctx)
+	// rollback по умолчанию, commit явно ниже (после commit rollback ничего не делает)
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	b := &pgx.Batch{}
+	for i, o := range orders {
+		queueUpsertOrder(b, o, &applied[i])
+	}
+	if err := tx.SendBatch(ctx, b).Close(); err != nil {
+		return nil, err
+	}
+
+	// второй пачкой — дочерние таблицы и уведомления применённых заказов (нужен updated_at из БД)
+	b = &pgx.Batch{}
+	for i, o := range orders {
+		if !applied[i] {
+			continue
+		}
+		queueUpsertDetails(b, o)
+		if err := queueNotify(b, OrderChange{Op: OrderUpserted, OrderUID: o.OrderUID, UpdatedAt: o.UpdatedAt}); err != nil {
+			return nil, err
 		}
-	}()
+	}
+	if b.Len() > 0 {
+		if err := tx.SendBatch(ctx, b).Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
 
-	// orders
-	_, err = tx.Exec(ctx, `
+// queueUpsertOrder ставит в пачку upsert шапки заказа; *applied = false, если сохранённая версия не старше.
+func queueUpsertOrder(b *pgx.Batch, o *Order, applied *bool) {
+	b.Queue(`
 		INSERT INTO orders(
 		  order_uid, track_number, entry, locale, internal_signature,
-		  customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, updated_at
-		) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11, now())
+		  customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, updated_at, version, version_seq
+		) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11, now(), $12, $13)
 		ON CONFLICT(order_uid) DO UPDATE SET
 		  track_number=EXCLUDED.track_number,
 		  entry=EXCLUDED.entry,
@@ -48,15 +109,27 @@ func (r *Repo) Upsert(ctx context.Context, o *Order) error {
 		  sm_id=EXCLUDED.sm_id,
 		  date_created=EXCLUDED.date_created,
 		  oof_shard=EXCLUDED.oof_shard,
-		  updated_at=now()
+		  updated_at=now(),
+		  version=EXCLUDED.version,
+		  version_seq=EXCLUDED.version_seq
+		WHERE (orders.version, orders.version_seq) < (EXCLUDED.version, EXCLUDED.version_seq)
+		RETURNING updated_at
 	`, o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature,
-		o.CustomerID, o.DeliveryService, o.ShardKey, o.SmID, o.DateCreated, o.OofShard)
-	if err != nil {
+		o.CustomerID, o.DeliveryService, o.ShardKey, o.SmID, o.DateCreated, o.OofShard, o.Version, o.VersionSeq,
+	).QueryRow(func(row pgx.Row) error {
+		err := row.Scan(&o.UpdatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil // устаревшая версия
+		}
+		*applied = err == nil
 		return err
-	}
+	})
+}
 
+// queueUpsertDetails ставит в пачку delivery, payment и товары заказа.
+func queueUpsertDetails(b *pgx.Batch, o *Order) {
 	// deliveries (1:1)
-	_, err = tx.Exec(ctx, `
+	b.Queue(`
 		INSERT INTO deliveries(
 		  order_uid, name, phone, zip, city, address, region, email
 		) VALUES($1,$2,$3,$4,$5,$6,$7,$8)
@@ -65,12 +138,9 @@ func (r *Repo) Upsert(ctx context.Context, o *Order) error {
 		  city=EXCLUDED.city, address=EXCLUDED.address, region=EXCLUDED.region, email=EXCLUDED.email
 	`, o.OrderUID, o.Delivery.Name, o.Delivery.Phone, o.Delivery.Zip, o.Delivery.City,
 		o.Delivery.Address, o.Delivery.Region, o.Delivery.Email)
-	if err != nil {
-		return err
-	}
 
 	// payments (1:1)
-	_, err = tx.Exec(ctx, `
+	b.Queue(`
 		INSERT INTO payments(
 		  order_uid, transaction, request_id, currency, provider,
 		  amount, payment_dt, bank, delivery_cost, goods_total, custom_fee
@@ -88,18 +158,11 @@ func (r *Repo) Upsert(ctx context.Context, o *Order) error {
 		  custom_fee=EXCLUDED.custom_fee
 	`, o.OrderUID, o.Payment.Transaction, o.Payment.RequestID, o.Payment.Currency, o.Payment.Provider,
 		o.Payment.Amount, o.Payment.PaymentDT, o.Payment.Bank, o.Payment.DeliveryCost, o.Payment.GoodsTotal, o.Payment.CustomFee)
-	if err != nil {
-		return err
-	}
 
 	// items — удаление и вставка
-	if _, err = tx.Exec(ctx, `DELETE FROM items WHERE order_uid=$1`, o.OrderUID); err != nil {
-		return err
-	}
-
-	// INSERTЫ
+	b.Queue(`DELETE FROM items WHERE order_uid=$1`, o.OrderUID)
 	for _, it := range o.Items {
-		_, err = tx.Exec(ctx, `
+		b.Queue(`
 			INSERT INTO items(
 			  order_uid, chrt_id, track_number, price, rid, name,
 			  sale, size, total_price, nm_id, brand, status
@@ -117,28 +180,58 @@ func (r *Repo) Upsert(ctx context.Context, o *Order) error {
 			  status=EXCLUDED.status
 		`, o.OrderUID, it.ChrtID, it.TrackNumber, it.Price, it.RID, it.Name,
 			it.Sale, it.Size, it.TotalPrice, it.NmID, it.Brand, it.Status)
-		if err != nil {
-			return err
-		}
 	}
+}
 
-	if err := tx.Commit(ctx); err != nil {
+// Delete удаляет заказ (дочерние таблицы — через ON DELETE CASCADE) и уведомляет реплики.
+func (r *Repo) Delete(ctx context.Context, id string) (bool, error) {
+	tx, err := r.Pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx, `DELETE FROM orders WHERE order_uid=$1`, id)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	if err := notify(ctx, tx, OrderChange{Op: OrderDeleted, OrderUID: id, UpdatedAt: time.Now()}); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+func queueNotify(b *pgx.Batch, ch OrderChange) error {
+	p, err := json.Marshal(ch)
+	if err != nil {
 		return err
 	}
-	tx = nil
+	b.Queue(`SELECT pg_notify($1, $2)`, OrdersChannel, string(p))
 	return nil
 }
 
+func notify(ctx context.Context, tx pgx.Tx, ch OrderChange) error {
+	b, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `SELECT pg_notify($1, $2)`, OrdersChannel, string(b))
+	return err
+}
+
 func (r *Repo) Get(ctx context.Context, id string) (*Order, bool, error) {
 	// orders
 	var o Order
 	err := r.Pool.QueryRow(ctx, `
 		SELECT order_uid, track_number, entry, locale, internal_signature,
-		       customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard
+		       customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, updated_at, version, version_seq
 		FROM orders WHERE order_uid=$1
 	`, id).Scan(
 		&o.OrderUID, &o.TrackNumber, &o.Entry, &o.Locale, &o.InternalSignature,
-		&o.CustomerID, &o.DeliveryService, &o.ShardKey, &o.SmID, &o.DateCreated, &o.OofShard,
+		&o.CustomerID, &o.DeliveryService, &o.ShardKey, &o.SmID, &o.DateCreated, &o.OofShard, &o.UpdatedAt, &o.Version, &o.VersionSeq,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -202,31 +295,8 @@ func (r *Repo) Get(ctx context.Context, id string) (*Order, bool, error) {
 	return &o, true, nil
 }
 
-// тут прогремаю кэш - беру последние N заказов по updated_at
-func (r *Repo) LoadRecent(ctx context.Context, n int) ([]*Order, error) {
-	rows, err := r.Pool.Query(ctx, `
-		SELECT order_uid
-		FROM orders
-		ORDER BY updated_at DESC
-		LIMIT $1
-	`, n)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var ids []string
-	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
-			return nil, err
-		}
-		ids = append(ids, id)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-
+// GetMany читает заказы по списку id, сохраняя порядок; отсутствующие пропускаются.
+func (r *Repo) GetMany(ctx context.Context, ids []string) ([]*Order, error) {
 	out := make([]*Order, 0, len(ids))
 	for _, id := range ids {
 		o, ok, err := r.Get(ctx, id)
@@ -241,7 +311,67 @@ func (r *Repo) LoadRecent(ctx context.Context, n int) ([]*Order, error) {
 }
 
 func (r *Repo) ListRecentIDs(ctx context.Context, n int) ([]string, error) {
-	rows, err := r.Pool.Query(ctx, `SELECT order_uid FROM orders ORDER BY updated_at DESC LIMIT $1`, n)
+	return r.queryIDs(ctx, `SELECT order_uid FROM orders ORDER BY updated_at DESC LIMIT $1`, n)
+}
+
+// ListUpdatedSinceIDs — id заказов, изменённых после since, от новых к старым.
+func (r *Repo) ListUpdatedSinceIDs(ctx context.Context, since time.Time, n int) ([]string, error) {
+	return r.queryIDs(ctx, `
+		SELECT order_uid FROM orders
+		WHERE updated_at > $1
+		ORDER BY updated_at DESC
+		LIMIT $2
+	`, since, n)
+}
+
+// ListPopularIDs — самые читаемые заказы по счётчикам order_access.
+func (r *Repo) ListPopularIDs(ctx context.Context, n int) ([]string, error) {
+	return r.queryIDs(ctx, `SELECT order_uid FROM order_access ORDER BY hits DESC, last_access DESC LIMIT $1`, n)
+}
+
+// ListCreatedSinceIDs — заказы, созданные после since (по date_created), новые первыми.
+func (r *Repo) ListCreatedSinceIDs(ctx context.Context, since time.Time, n int) ([]string, error) {
+	return r.queryIDs(ctx, `
+		SELECT order_uid FROM orders
+		WHERE date_created >= $1
+		ORDER BY date_created DESC
+		LIMIT $2
+	`, since, n)
+}
+
+// AddAccess прибавляет накопленные счётчики чтений одним батчем.
+func (r *Repo) AddAccess(ctx context.Context, hits map[string]int64) error {
+	b := &pgx.Batch{}
+	for id, n := range hits {
+		b.Queue(`
+			INSERT INTO order_access(order_uid, hits, last_access)
+			SELECT order_uid, $2, now() FROM orders WHERE order_uid=$1
+			ON CONFLICT(order_uid) DO UPDATE SET
+			  hits=order_access.hits + EXCLUDED.hits,
+			  last_access=now()
+		`, id, n)
+	}
+	return r.Pool.SendBatch(ctx, b).Close()
+}
+
+// ListIDsByTrackNumber, ListIDsByCustomer, ListIDsByTransaction — order_uid по вторичным ключам, новые первыми.
+func (r *Repo) ListIDsByTrackNumber(ctx context.Context, track string, n int) ([]string, error) {
+	return r.queryIDs(ctx, `SELECT order_uid FROM orders WHERE track_number=$1 ORDER BY date_created DESC LIMIT $2`, track, n)
+}
+
+func (r *Repo) ListIDsByCustomer(ctx context.Context, customerID string, n int) ([]string, error) {
+	return r.queryIDs(ctx, `SELECT order_uid FROM orders WHERE customer_id=$1 ORDER BY date_created DESC LIMIT $2`, customerID, n)
+}
+
+func (r *Repo) ListIDsByTransaction(ctx context.Context, tx string, n int) ([]string, error) {
+	return r.queryIDs(ctx, `
+		SELECT o.order_uid FROM orders o JOIN payments p USING(order_uid)
+		WHERE p.transaction=$1 ORDER BY o.date_created DESC LIMIT $2
+	`, tx, n)
+}
+
+func (r *Repo) queryIDs(ctx context.Context, sql string, args ...any) ([]string, error) {
+	rows, err := r.Pool.Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Минимальный клиент протокола RESP2 (Redis и совместимые хранилища).
+
+// respError — ответ сервера вида "-ERR ...".
+type respError string
+
+func (e respError) Error() string { return string(e) }
+
+type respConn struct {
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func dialRESP(addr string, timeout time.Duration) (*respConn, error) {
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &respConn{c: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}, nil
+}
+
+func (rc *respConn) Close() error { return rc.c.Close() }
+
+// send пишет команды одним пакетом и читает столько же ответов (pipelining).
+// Ошибки уровня протокола (respError) возвращаются в ответах, а не как err.
+func (rc *respConn) send(timeout time.Duration, cmds ...[]string) ([]any, error) {
+	if timeout > 0 {
+		if err := rc.c.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+	for _, args := range cmds {
+		if err := writeCommand(rc.w, args); err != nil {
+			return nil, err
+		}
+	}
+	if err := rc.w.Flush(); err != nil {
+		return nil, err
+	}
+	out := make([]any, len(cmds))
+	for i := range cmds {
+		v, err := readReply(rc.r)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func writeCommand(w *bufio.Writer, args []string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, a := range args {
+		if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(a), a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readReply разбирает один ответ: string ("+"), respError ("-"), int64 (":"),
+// []byte или nil ("$"), []any или nil ("*").
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("resp: malformed line %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return respError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("resp: bad bulk length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("resp: bad array length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			if arr[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	default:
+		return nil, fmt.Errorf("resp: unknown reply type %q", kind)
+	}
+}
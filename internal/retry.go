@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// RetryOptions — повторы записи в БД для временных ошибок: до Max попыток после первой,
+// задержка растёт от Initial вдвое до MaxDelay, фактическая задержка случайна в [d/2, d].
+type RetryOptions struct {
+	Max      int
+	Initial  time.Duration
+	MaxDelay time.Duration
+}
+
+func (r RetryOptions) delay(attempt int) time.Duration {
+	d := r.Initial
+	for i := 1; i < attempt && d < r.MaxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, r.MaxDelay)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// sleep ждёт задержку перед попыткой attempt (с 1); false — ctx отменён.
+func (r RetryOptions) sleep(ctx context.Context, attempt int) bool {
+	t := time.NewTimer(r.delay(attempt))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// IsTransient сообщает, имеет ли смысл повторить операцию с БД. Ошибки PostgreSQL классифицируются
+// по SQLSTATE: временные — соединение (08), сериализация и взаимоблокировка (40001, 40P01),
+// нехватка ресурсов (53), остановка сервера (57P01–57P03), таймауты (57014, 55P03).
+// Нарушения ограничений, ошибки данных и синтаксиса постоянны. Ошибки сети, обрыв и установление
+// соединения, таймауты клиента временные.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "40001", "40P01", "55P03", "57014", "57P01", "57P02", "57P03":
+			return true
+		}
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "53")
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	var connErr *pgconn.ConnectError
+	return pgconn.SafeToRetry(err) || pgconn.Timeout(err) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.As(err, &netErr) || errors.As(err, &connErr)
+}
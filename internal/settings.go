@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// redacted — подстановка вместо секретов в --print-config.
+const redacted = "<redacted>"
+
+// secretValue печатается скрытым (shown), а при сравнении конфигураций учитывается настоящее значение.
+type secretValue struct{ v, shown string }
+
+func (s secretValue) MarshalYAML() (any, error) { return s.shown, nil }
+
+var pgPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// loader читает настройки: сначала переменная окружения KEY, затем ключ key из файла конфигурации.
+// Ошибки не прерывают загрузку, а копятся, чтобы показать их все разом.
+type loader struct {
+	file map[string]string
+	errs []error
+	seen map[string]any // эффективные значения для --print-config
+}
+
+// readConfigFile разбирает плоский YAML: ключи — имена переменных окружения в нижнем регистре
+// (http_addr, cache_ttl, ...), значения — скаляры или списки (склеиваются через запятую).
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	out := make(map[string]string, len(raw))
+	var errs []error
+	for k, v := range raw {
+		switch x := v.(type) {
+		case nil:
+		case []any:
+			parts := make([]string, len(x))
+			for i, p := range x {
+				parts[i] = fmt.Sprint(p)
+			}
+			out[strings.ToLower(k)] = strings.Join(parts, ",")
+		case map[string]any:
+			errs = append(errs, fmt.Errorf("%s: key %q: nested sections are not supported", path, k))
+		default:
+			out[strings.ToLower(k)] = fmt.Sprint(x)
+		}
+	}
+	return out, errors.Join(errs...)
+}
+
+func (l *loader) errorf(k, format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf("%s: "+format, append([]any{k}, args...)...))
+}
+
+func (l *loader) raw(k string) (string, bool) {
+	if v, ok := os.LookupEnv(k); ok && v != "" {
+		return v, true
+	}
+	v, ok := l.file[strings.ToLower(k)]
+	return v, ok && v != ""
+}
+
+func (l *loader) str(k, def string) string {
+	v, ok := l.raw(k)
+	if !ok {
+		v = def
+	}
+	l.seen[k] = v
+	return v
+}
+
+func (l *loader) required(k string) string {
+	v, ok := l.raw(k)
+	if !ok {
+		l.errorf(k, "is not set")
+	}
+	l.seen[k] = v
+	return v
+}
+
+func (l *loader) secret(k string) string {
+	v, _ := l.raw(k)
+	shown := ""
+	if v != "" {
+		shown = redacted
+	}
+	l.seen[k] = secretValue{v, shown}
+	return v
+}
+
+func (l *loader) oneOf(k, def string, allowed ...string) string {
+	v := l.str(k, def)
+	if !slices.Contains(allowed, v) {
+		l.errorf(k, "%q is not one of %s", v, strings.Join(allowed, ", "))
+	}
+	return v
+}
+
+// intIn читает целое в диапазоне [lo, hi].
+func (l *loader) intIn(k string, def, lo, hi int) int {
+	v, ok := l.raw(k)
+	if !ok {
+		l.seen[k] = def
+		return def
+	}
+	l.seen[k] = v
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		l.errorf(k, "%q is not an integer", v)
+		return def
+	}
+	l.seen[k] = n
+	if n < lo || n > hi {
+		l.errorf(k, "%d is out of range [%d, %d]", n, lo, hi)
+	}
+	return n
+}
+
+func (l *loader) boolean(k string, def bool) bool {
+	v, ok := l.raw(k)
+	if !ok {
+		l.seen[k] = def
+		return def
+	}
+	l.seen[k] = v
+	b, err := strconv.ParseBool(v) // "1"/"true" -> true
+	if err != nil {
+		l.errorf(k, "%q is not a boolean", v)
+		return def
+	}
+	l.seen[k] = b
+	return b
+}
+
+// duration читает неотрицательную длительность в формате time.ParseDuration ("30s", "5m").
+func (l *loader) duration(k string, def time.Duration) time.Duration {
+	v, ok := l.raw(k)
+	if !ok {
+		l.seen[k] = def.String()
+		return def
+	}
+	l.seen[k] = v
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		l.errorf(k, "%q is not a duration", v)
+		return def
+	}
+	if d < 0 {
+		l.errorf(k, "must not be negative")
+	}
+	return d
+}
+
+// hostPort проверяет адрес вида host:port (host может быть пустым, как в ":8081").
+func (l *loader) hostPort(k, v string) {
+	_, port, err := net.SplitHostPort(v)
+	if err != nil {
+		l.errorf(k, "%q: %v", v, err)
+		return
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		l.errorf(k, "%q: bad port", v)
+	}
+}
+
+func (l *loader) brokers(k string) []string {
+	v := l.required(k)
+	if v == "" {
+		return nil
+	}
+	list := strings.Split(v, ",")
+	for i, b := range list {
+		list[i] = strings.TrimSpace(b)
+		if list[i] == "" {
+			l.errorf(k, "empty broker address in %q", v)
+			continue
+		}
+		_, port, err := net.SplitHostPort(list[i])
+		if err != nil || port == "" || strings.HasPrefix(list[i], ":") {
+			l.errorf(k, "broker %q must be host:port", list[i])
+			continue
+		}
+		l.hostPort(k, list[i])
+	}
+	return list
+}
+
+func (l *loader) pgURL(k string) string {
+	v := l.required(k)
+	if v == "" {
+		return ""
+	}
+	if _, err := pgx.ParseConfig(v); err != nil {
+		l.errorf(k, "invalid connection string: %v", err)
+		l.seen[k] = secretValue{v, redacted}
+		return v
+	}
+	switch u, err := url.Parse(v); {
+	case err == nil && u.Scheme != "":
+		l.seen[k] = secretValue{v, u.Redacted()}
+	default: // host=... password=...
+		l.seen[k] = secretValue{v, pgPassword.ReplaceAllString(v, "${1}"+redacted)}
+	}
+	return v
+}
+
+// printSettings пишет эффективную конфигурацию в формате файла конфигурации (секреты скрыты).
+func printSettings(w io.Writer, seen map[string]any) error {
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b, err := yaml.Marshal(map[string]any{strings.ToLower(k): seen[k]})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
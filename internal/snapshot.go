@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Формат файла снимка кэша:
+//
+//	magic "OCSNAP" | version uint16 | created_at int64 (unix nano) | len uint64 | payload | crc32(payload)
+//
+// payload — gob-кодированный []*Order (gob, а не JSON: нужен UpdatedAt, скрытый из JSON).
+const (
+	snapshotMagic   = "OCSNAP"
+	snapshotVersion = 1
+
+	// snapshotOverlap — запас на расхождение часов приложения и БД при дозагрузке изменений.
+	snapshotOverlap = time.Minute
+)
+
+var errSnapshotCorrupt = errors.New("snapshot: corrupt file")
+
+// SaveSnapshot атомарно (через временный файл и rename) записывает содержимое кэша в path.
+func SaveSnapshot(path string, c *Cache) error {
+	var payload bytes.Buffer
+	list := c.Orders()
+	if err := gob.NewEncoder(&payload).Encode(list); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // после rename — no-op
+
+	w := bufio.NewWriter(tmp)
+	w.WriteString(snapshotMagic)
+	binary.Write(w, binary.BigEndian, uint16(snapshotVersion))
+	binary.Write(w, binary.BigEndian, time.Now().UnixNano())
+	binary.Write(w, binary.BigEndian, uint64(payload.Len()))
+	w.Write(payload.Bytes())
+	binary.Write(w, binary.BigEndian, crc32.ChecksumIEEE(payload.Bytes()))
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	log.Printf("[SNAPSHOT] saved %d orders to %s", len(list), path)
+	return nil
+}
+
+// ReadSnapshot читает и проверяет файл снимка.
+func ReadSnapshot(path string) ([]*Order, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var hdr struct {
+		Magic   [len(snapshotMagic)]byte
+		Version uint16
+		Created int64
+		Len     uint64
+	}
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %v", errSnapshotCorrupt, err)
+	}
+	if string(hdr.Magic[:]) != snapshotMagic {
+		return nil, time.Time{}, fmt.Errorf("%w: bad magic", errSnapshotCorrupt)
+	}
+	if hdr.Version != snapshotVersion {
+		return nil, time.Time{}, fmt.Errorf("snapshot: unsupported version %d", hdr.Version)
+	}
+	if st, err := f.Stat(); err == nil && hdr.Len > uint64(st.Size()) {
+		return nil, time.Time{}, fmt.Errorf("%w: bad length", errSnapshotCorrupt)
+	}
+	payload := make([]byte, hdr.Len)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %v", errSnapshotCorrupt, err)
+	}
+	var sum uint32
+	if err := binary.Read(r, binary.BigEndian, &sum); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %v", errSnapshotCorrupt, err)
+	}
+	if sum != crc32.ChecksumIEEE(payload) {
+		return nil, time.Time{}, fmt.Errorf("%w: checksum mismatch", errSnapshotCorrupt)
+	}
+	var list []*Order
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&list); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %v", errSnapshotCorrupt, err)
+	}
+	return list, time.Unix(0, hdr.Created), nil
+}
+
+// RestoreSnapshot прогревает кэш процесса из снимка и дочитывает из БД только заказы, изменённые после него.
+// Общий уровень (L2) не заполняется: снимок может быть старше того, что туда уже записали другие реплики.
+// Если снимок старше maxAge или изменений больше n, возвращает ошибку — вызывающий
+// должен откатиться к обычному прогреву (Warmer).
+func RestoreSnapshot(ctx context.Context, path string, maxAge time.Duration, cache *Cache, repo *Repo, n int) error {
+	list, at, err := ReadSnapshot(path)
+	if err != nil {
+		return err
+	}
+	if age := time.Since(at); maxAge > 0 && age > maxAge {
+		return fmt.Errorf("snapshot is too old (%s)", age.Round(time.Second))
+	}
+	ids, err := repo.ListUpdatedSinceIDs(ctx, at.Add(-snapshotOverlap), n+1)
+	if err != nil {
+		return err
+	}
+	if len(ids) > n {
+		return fmt.Errorf("more than %d orders changed since snapshot", n)
+	}
+	fresh, err := repo.GetMany(ctx, ids)
+	if err != nil {
+		return err
+	}
+	cache.Warm(list)
+	cache.Warm(fresh)
+	log.Printf("[SNAPSHOT] restored %d orders from %s, %d refreshed from DB", len(list), path, len(fresh))
+	return nil
+}
@@ -0,0 +1,136 @@
+package internal
+
+import "sync/atomic"
+
+// Источники ответа двухуровневого кэша (заголовок X-Source).
+const (
+	SourceL1 = "cache-l1"
+	SourceL2 = "cache-l2"
+)
+
+// TieredOptions — политика записи по уровням.
+// ReadThrough: заполнять уровень данными, прочитанными ниже (из L2 или из БД).
+// WriteThrough: записывать в уровень изменения от consumer'а (Set/Warm).
+type TieredOptions struct {
+	L1ReadThrough  bool
+	L1WriteThrough bool
+	L2ReadThrough  bool
+	L2WriteThrough bool
+}
+
+// CacheFiller — кэш, различающий заполнение после чтения из БД (Fill) и запись изменений (Set).
+// Fill не должен заменять более новую версию заказа.
+type CacheFiller interface {
+	Fill(o *Order)
+}
+
+// fillCache кладёт прочитанный из БД заказ в кэш: через Fill, если кэш его поддерживает, иначе через Set.
+func fillCache(cache OrderCache, o *Order) {
+	if f, ok := cache.(CacheFiller); ok {
+		f.Fill(o)
+		return
+	}
+	cache.Set(o)
+}
+
+// TieredCache — локальный LRU (L1) перед общим сетевым хранилищем (L2) перед Repo.
+// Дополнительные возможности (индексы, отрицательный кэш, статистика, выборка) берутся у L1.
+type TieredCache struct {
+	L1   *Cache
+	L2   OrderCache
+	opts atomic.Pointer[TieredOptions]
+}
+
+func NewTieredCache(l1 *Cache, l2 OrderCache, opts TieredOptions) *TieredCache {
+	t := &TieredCache{L1: l1, L2: l2}
+	t.SetOptions(opts)
+	return t
+}
+
+// SetOptions меняет политику записи по уровням на ходу.
+func (t *TieredCache) SetOptions(opts TieredOptions) { t.opts.Store(&opts) }
+
+func (t *TieredCache) Get(id string) (*Order, bool) {
+	co, ok := t.GetEncoded(id)
+	return co.Order, ok
+}
+
+// GetEncoded ищет в L1, затем в L2; CachedOrder.Source сообщает, какой уровень ответил.
+func (t *TieredCache) GetEncoded(id string) (CachedOrder, bool) {
+	if co, ok := t.L1.GetEncoded(id); ok {
+		co.Source = SourceL1
+		return co, true
+	}
+	o, ok := t.L2.Get(id)
+	if !ok {
+		return CachedOrder{}, false
+	}
+	if t.opts.Load().L1ReadThrough {
+		t.L1.Set(o)
+	}
+	return CachedOrder{Order: o, Source: SourceL2}, true
+}
+
+func (t *TieredCache) Set(o *Order) {
+	opts := t.opts.Load()
+	if opts.L2WriteThrough {
+		t.L2.Set(o)
+	} else {
+		t.L2.Delete(o.OrderUID) // иначе промах L1 отдал бы из L2 старую версию
+	}
+	if opts.L1WriteThrough {
+		t.L1.Set(o)
+	} else {
+		t.L1.Delete(o.OrderUID) // не оставляем в L1 старую версию
+	}
+}
+
+func (t *TieredCache) Warm(list []*Order) {
+	opts := t.opts.Load()
+	if opts.L2WriteThrough {
+		t.L2.Warm(list)
+	}
+	if opts.L1WriteThrough {
+		t.L1.Warm(list)
+	}
+}
+
+// Fill кладёт прочитанный из БД заказ в уровни с включённым read-through. Ни один уровень не заменяет
+// более новую версию (Cache.set, RedisCache.Fill). Без read-through в L1 его копия удаляется:
+// следующий промах L1 прочитает L2.
+func (t *TieredCache) Fill(o *Order) {
+	opts := t.opts.Load()
+	if opts.L2ReadThrough {
+		fillCache(t.L2, o)
+	}
+	if opts.L1ReadThrough {
+		t.L1.Set(o)
+	} else {
+		t.L1.Delete(o.OrderUID)
+	}
+}
+
+func (t *TieredCache) Delete(orderUID string) {
+	t.L2.Delete(orderUID)
+	t.L1.Delete(orderUID)
+}
+
+func (t *TieredCache) DeleteAllItems() {
+	t.L2.DeleteAllItems()
+	t.L1.DeleteAllItems()
+}
+
+func (t *TieredCache) SetMissing(id string)          { t.L1.SetMissing(id) }
+func (t *TieredCache) IsMissing(id string) bool      { return t.L1.IsMissing(id) }
+func (t *TieredCache) Stats() CacheStats             { return t.L1.Stats() }
+func (t *TieredCache) SampleIDs(n int) []string      { return t.L1.SampleIDs(n) }
+func (t *TieredCache) Peek(id string) (*Order, bool) { return t.L1.Peek(id) }
+func (t *TieredCache) GetByTrackNumber(track string) []*Order {
+	return t.L1.GetByTrackNumber(track)
+}
+func (t *TieredCache) GetByCustomer(customerID string) []*Order {
+	return t.L1.GetByCustomer(customerID)
+}
+func (t *TieredCache) GetByTransaction(tx string) []*Order {
+	return t.L1.GetByTransaction(tx)
+}
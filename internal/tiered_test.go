@@ -0,0 +1,28 @@
+package internal
+
+import "testing"
+
+func newTestTieredCache(t *testing.T, opts TieredOptions) (*TieredCache, *RedisCache) {
+	t.Helper()
+	l2 := newTestRedisCache(t, newFakeRedis(t, ""), 0)
+	return NewTieredCache(NewCache(CacheOptions{}), l2, opts), l2
+}
+
+// Без write-through в L2 запись consumer'а должна убрать старую версию из L2, иначе её отдаст промах L1.
+func TestTieredCacheSetWithoutL2WriteThrough(t *testing.T) {
+	c, l2 := newTestTieredCache(t, TieredOptions{L1ReadThrough: true, L1WriteThrough: true, L2ReadThrough: true})
+	c.Fill(testOrder("a", 1))
+
+	c.SetOptions(TieredOptions{L1ReadThrough: true, L2ReadThrough: true})
+	c.Set(testOrder("a", 2))
+
+	if _, ok := l2.Get("a"); ok {
+		t.Error("old version left in L2")
+	}
+	if co, ok := c.GetEncoded("a"); ok {
+		t.Errorf("got version %d from %s, want a miss", co.Order.Version, co.Source)
+	}
+	if _, ok := c.L1.Get("a"); ok {
+		t.Error("old version copied back into L1")
+	}
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Currencies — допустимые коды валют (ISO 4217) в payment.currency.
+var Currencies = map[string]bool{
+	"RUB": true, "USD": true, "EUR": true, "GBP": true, "CNY": true, "JPY": true, "CHF": true,
+	"KZT": true, "BYN": true, "UAH": true, "AMD": true, "AZN": true, "GEL": true, "KGS": true,
+	"UZS": true, "TJS": true, "TMT": true, "MDL": true, "TRY": true, "ILS": true, "AED": true,
+	"INR": true, "PLN": true, "CZK": true, "SEK": true, "NOK": true, "DKK": true, "CAD": true,
+	"AUD": true, "HKD": true, "SGD": true, "KRW": true,
+}
+
+// FieldError — ошибка одного поля; Field — путь в JSON заказа ("payment.amount", "items[0].price").
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError — все ошибки проверки заказа. В ответ API отдаётся как JSON-массив FieldError.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	parts := make([]string, len(e))
+	for i, f := range e {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return "invalid order: " + strings.Join(parts, "; ")
+}
+
+// Validate проверяет заказ перед записью: обязательные поля, неотрицательные суммы, состав товаров
+// и согласованность итогов. Возвращает ValidationError со всеми найденными ошибками или nil.
+func (o *Order) Validate() error {
+	var errs ValidationError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if o.OrderUID == "" {
+		add("order_uid", "is empty")
+	}
+	if o.TrackNumber == "" {
+		add("track_number", "is empty")
+	}
+	if o.Version < 0 {
+		add("version", "is negative: %d", o.Version)
+	}
+
+	p := o.Payment
+	if !Currencies[p.Currency] {
+		add("payment.currency", "unknown currency %q", p.Currency)
+	}
+	for _, f := range []struct {
+		name string
+		v    int
+	}{
+		{"payment.amount", p.Amount},
+		{"payment.delivery_cost", p.DeliveryCost},
+		{"payment.goods_total", p.GoodsTotal},
+		{"payment.custom_fee", p.CustomFee},
+	} {
+		if f.v < 0 {
+			add(f.name, "is negative: %d", f.v)
+		}
+	}
+	if p.Amount != p.GoodsTotal+p.DeliveryCost {
+		add("payment.amount", "%d != goods_total %d + delivery_cost %d", p.Amount, p.GoodsTotal, p.DeliveryCost)
+	}
+
+	if len(o.Items) == 0 {
+		add("items", "is empty")
+	}
+	for i, it := range o.Items {
+		field := fmt.Sprintf("items[%d].", i)
+		if it.Price < 0 {
+			add(field+"price", "is negative: %d", it.Price)
+		}
+		if it.TotalPrice < 0 {
+			add(field+"total_price", "is negative: %d", it.TotalPrice)
+		}
+		if it.Sale < 0 || it.Sale > 100 {
+			add(field+"sale", "%d is out of range [0, 100]", it.Sale)
+			continue
+		}
+		// total_price = price со скидкой sale%, округление в любую сторону; разница ровно в единицу
+		// допускается для producer'ов, отбрасывающих дробную часть после вычислений в float
+		if want := it.Price * (100 - it.Sale); abs(it.TotalPrice*100-want) > 100 {
+			add(field+"total_price", "%d does not match price %d with sale %d%%", it.TotalPrice, it.Price, it.Sale)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
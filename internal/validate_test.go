@@ -0,0 +1,35 @@
+package internal
+
+import "testing"
+
+func validItem(price, sale, total int) *Order {
+	o := testOrder("a", 1)
+	o.Items = []Item{{TrackNumber: o.TrackNumber, Price: price, Sale: sale, TotalPrice: total}}
+	o.Payment.GoodsTotal = total
+	o.Payment.Amount = total
+	return o
+}
+
+// Все пары цена/скидка, которые генерирует cmd/producer, в обоих вариантах округления.
+func TestValidateAcceptsProducerRounding(t *testing.T) {
+	for price := 100; price < 1000; price++ {
+		for _, sale := range []int{0, 10, 20, 30} {
+			for _, total := range []int{
+				price * (100 - sale) / 100,
+				int(float64(price) * (1 - float64(sale)/100)),
+			} {
+				if err := validItem(price, sale, total).Validate(); err != nil {
+					t.Fatalf("price=%d sale=%d total=%d: %v", price, sale, total, err)
+				}
+			}
+		}
+	}
+}
+
+func TestValidateRejectsWrongTotal(t *testing.T) {
+	err := validItem(170, 30, 117).Validate()
+	verr, ok := err.(ValidationError)
+	if !ok || len(verr) != 1 || verr[0].Field != "items[0].total_price" {
+		t.Fatalf("got %v, want a single items[0].total_price error", err)
+	}
+}
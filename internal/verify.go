@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Режимы обработки расхождений кэша с БД (VERIFY_MODE).
+const (
+	VerifyRepair = "repair" // записать в кэш версию из БД
+	VerifyEvict  = "evict"  // удалить запись, следующее чтение пойдёт в БД
+)
+
+// verifyRecent — сколько последних расхождений хранить для админки.
+const verifyRecent = 20
+
+// VerifierStats — накопленные счётчики сверки кэша с БД.
+type VerifierStats struct {
+	Runs     uint64       `json:"runs"`
+	Checked  uint64       `json:"checked"`
+	Diverged uint64       `json:"diverged"`
+	Repaired uint64       `json:"repaired"`
+	Evicted  uint64       `json:"evicted"`
+	Errors   uint64       `json:"errors"`
+	LastRun  time.Time    `json:"last_run"`
+	Recent   []Divergence `json:"recent"`
+}
+
+// Divergence — одно найденное расхождение.
+type Divergence struct {
+	OrderUID string    `json:"order_uid"`
+	Fields   []string  `json:"fields"` // пусто — заказа нет в БД
+	At       time.Time `json:"at"`
+}
+
+// Verifier периодически сверяет случайную выборку записей кэша с Repo.Get
+// и чинит (или удаляет) разошедшиеся записи.
+type Verifier struct {
+	cache   OrderCache
+	sampler SamplingCache
+	peeker  PeekingCache // чтения сверки не считаются в статистике и не продлевают жизнь записей в LRU
+	repo    *Repo
+	sample  int
+	mode    string
+
+	mu    sync.Mutex
+	stats VerifierStats
+}
+
+// NewVerifier возвращает nil, если бэкенд кэша не умеет отдавать выборку ключей и читать их без учёта.
+func NewVerifier(cache OrderCache, repo *Repo, sample int, mode string) *Verifier {
+	sampler, ok := cache.(SamplingCache)
+	if !ok {
+		return nil
+	}
+	peeker, ok := cache.(PeekingCache)
+	if !ok {
+		return nil
+	}
+	return &Verifier{cache: cache, sampler: sampler, peeker: peeker, repo: repo, sample: sample, mode: mode}
+}
+
+func (v *Verifier) Run(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			v.Verify(ctx)
+		}
+	}
+}
+
+// Verify выполняет один проход сверки и возвращает число найденных расхождений.
+func (v *Verifier) Verify(ctx context.Context) int {
+	var st VerifierStats
+	var found []Divergence
+	for _, id := range v.sampler.SampleIDs(v.sample) {
+		cached, ok := v.peeker.Peek(id)
+		if !ok {
+			continue // вытеснена или истекла между выборкой и чтением
+		}
+		st.Checked++
+		db, inDB, err := v.repo.Get(ctx, id)
+		if err != nil {
+			st.Errors++
+			log.Printf("[VERIFY] id=%s: %v", id, err)
+			continue
+		}
+		var fields []string
+		if inDB {
+			if fields = diffOrder(cached, db); len(fields) == 0 {
+				continue
+			}
+		}
+		st.Diverged++
+		found = append(found, Divergence{OrderUID: id, Fields: fields, At: time.Now()})
+		log.Printf("[VERIFY] id=%s diverged, in_db=%t fields=%v", id, inDB, fields)
+
+		switch {
+		case !inDB || v.mode == VerifyEvict:
+			v.cache.Delete(id)
+			st.Evicted++
+		default:
+			// consumer мог успеть записать более новую версию после нашего Repo.Get
+			if cur, ok := v.peeker.Peek(id); ok && cur.UpdatedAt.After(db.UpdatedAt) {
+				continue
+			}
+			fillCache(v.cache, db) // как и чтение при промахе, не заменяет более новую версию
+			st.Repaired++
+		}
+	}
+
+	v.mu.Lock()
+	v.stats.Runs++
+	v.stats.Checked += st.Checked
+	v.stats.Diverged += st.Diverged
+	v.stats.Repaired += st.Repaired
+	v.stats.Evicted += st.Evicted
+	v.stats.Errors += st.Errors
+	v.stats.LastRun = time.Now()
+	v.stats.Recent = append(v.stats.Recent, found...)
+	if n := len(v.stats.Recent); n > verifyRecent {
+		v.stats.Recent = v.stats.Recent[n-verifyRecent:]
+	}
+	v.mu.Unlock()
+	return int(st.Diverged)
+}
+
+func (v *Verifier) Stats() VerifierStats {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	st := v.stats
+	st.Recent = append([]Divergence(nil), v.stats.Recent...)
+	return st
+}
+
+// diffOrder сравнивает заказы поле за полем и возвращает имена разошедшихся полей верхнего уровня.
+// UpdatedAt не сравнивается; время сравнивается через Equal (часовой пояс из БД может отличаться),
+// товары сравниваются без учёта порядка.
+func diffOrder(a, b *Order) []string {
+	var out []string
+	va, vb := reflect.ValueOf(*a), reflect.ValueOf(*b)
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		fa, fb := va.Field(i).Interface(), vb.Field(i).Interface()
+		switch x := fa.(type) {
+		case time.Time:
+			if name == "UpdatedAt" || x.Equal(fb.(time.Time)) {
+				continue
+			}
+		case []Item:
+			if sameItems(x, fb.([]Item)) {
+				continue
+			}
+		default:
+			if reflect.DeepEqual(fa, fb) {
+				continue
+			}
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+// sameItems сравнивает товары без учёта порядка (Repo.Get сортирует их по chrt_id).
+func sameItems(a, b []Item) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byChrt := func(items []Item) []Item {
+		s := slices.Clone(items)
+		slices.SortFunc(s, func(x, y Item) int { return cmp.Compare(x.ChrtID, y.ChrtID) })
+		return s
+	}
+	return slices.Equal(byChrt(a), byChrt(b))
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Стратегии прогрева кэша (WARM_STRATEGY).
+const (
+	WarmRecent  = "recent"  // последние N по updated_at
+	WarmPopular = "popular" // самые читаемые по order_access
+	WarmCreated = "created" // созданные за последние WARM_CREATED_WINDOW
+	WarmFile    = "file"    // список order_uid из WARM_FILE
+)
+
+// warmChunk — сколько заказов читаем из БД между записями в кэш: уже прочитанное
+// становится доступным, пока прогрев продолжается.
+const warmChunk = 100
+
+type WarmOptions struct {
+	Strategy      string
+	N             int
+	Budget        time.Duration // 0 — без ограничения по времени
+	CreatedWindow time.Duration
+	File          string
+}
+
+type Warmer struct {
+	cache OrderCache
+	repo  *Repo
+	opts  WarmOptions
+}
+
+func NewWarmer(cache OrderCache, repo *Repo, opts WarmOptions) *Warmer {
+	return &Warmer{cache: cache, repo: repo, opts: opts}
+}
+
+// Run прогревает кэш выбранной стратегией, пока не исчерпан бюджет времени.
+// Возвращает число загруженных заказов; по истечении бюджета — частичный результат без ошибки.
+func (w *Warmer) Run(ctx context.Context) (int, error) {
+	start := time.Now()
+	if w.opts.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.opts.Budget)
+		defer cancel()
+	}
+
+	ids, err := w.ids(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("warm %s: %w", w.opts.Strategy, err)
+	}
+
+	loaded := 0
+	for i := 0; i < len(ids); i += warmChunk {
+		list, err := w.repo.GetMany(ctx, ids[i:min(i+warmChunk, len(ids))])
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				log.Printf("[WARM] %s: budget %s exhausted, %d/%d orders loaded", w.opts.Strategy, w.opts.Budget, loaded, len(ids))
+				return loaded, nil
+			}
+			return loaded, fmt.Errorf("warm %s: %w", w.opts.Strategy, err)
+		}
+		w.cache.Warm(list)
+		loaded += len(list)
+	}
+	log.Printf("[WARM] %s: %d orders loaded in %s", w.opts.Strategy, loaded, time.Since(start).Round(time.Millisecond))
+	return loaded, nil
+}
+
+// RunWhenReady выполняет Run и, если прогрев упал из-за недоступной БД, повторяет его,
+// как только БД снова ответит на ping. Прочие ошибки (например, нечитаемый WARM_FILE) не повторяются.
+func (w *Warmer) RunWhenReady(ctx context.Context) (int, error) {
+	for {
+		n, err := w.Run(ctx)
+		if err == nil || ctx.Err() != nil {
+			return n, err
+		}
+		pctx, cancel := context.WithTimeout(ctx, pingTimeout)
+		pingErr := w.repo.Pool.Ping(pctx)
+		cancel()
+		if pingErr == nil {
+			return n, err
+		}
+		log.Printf("[WARM] %v; retrying once the database is reachable", err)
+		if err := WaitForDB(ctx, w.repo.Pool, 0); err != nil {
+			return n, err
+		}
+	}
+}
+
+func (w *Warmer) ids(ctx context.Context) ([]string, error) {
+	switch w.opts.Strategy {
+	case "", WarmRecent:
+		return w.repo.ListRecentIDs(ctx, w.opts.N)
+	case WarmPopular:
+		return w.repo.ListPopularIDs(ctx, w.opts.N)
+	case WarmCreated:
+		return w.repo.ListCreatedSinceIDs(ctx, time.Now().Add(-w.opts.CreatedWindow), w.opts.N)
+	case WarmFile:
+		return readIDFile(w.opts.File, w.opts.N)
+	default:
+		return nil, fmt.Errorf("unknown strategy %q", w.opts.Strategy)
+	}
+}
+
+// readIDFile читает order_uid по одному на строку; пустые строки и строки с # пропускаются.
+func readIDFile(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ids []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() && len(ids) < n {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	return ids, sc.Err()
+}